@@ -0,0 +1,79 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"io"
+	"sync"
+)
+
+// bufferedPipe is a pipe whose writing never blocks, as the data is buffered until it is read.
+// It is used to feed the data received by the event relays to the readers, without blocking the relays on slow (or absent) readers.
+type bufferedPipe struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	// err is returned once the buffered data is consumed, which is io.EOF if the writer closes normally.
+	err error
+	// readClosed indicates the reader closes the pipe, after which the written data is dropped.
+	readClosed bool
+}
+
+func newBufferedPipe() *bufferedPipe {
+	p := &bufferedPipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *bufferedPipe) write(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil || p.readClosed {
+		return
+	}
+	p.buf = append(p.buf, b...)
+	p.cond.Broadcast()
+}
+
+// closeWrite closes the writing side, the reader gets the err after consuming the buffered data.
+// A nil err is treated as io.EOF.
+func (p *bufferedPipe) closeWrite(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	p.err = err
+	p.cond.Broadcast()
+}
+
+// Read blocks until there is data available, or the writing side is closed.
+func (p *bufferedPipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 && p.err == nil && !p.readClosed {
+		p.cond.Wait()
+	}
+	if p.readClosed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p.buf) == 0 {
+		return 0, p.err
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// Close closes the reading side, the buffered and further written data is dropped.
+func (p *bufferedPipe) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readClosed = true
+	p.buf = nil
+	p.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,110 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// CloseKind tells why a connection is closed.
+type CloseKind int
+
+const (
+	// CloseWorkerClosed means the worker closed itself, via SelfConn.Close or SelfSharedConn.Close (or their CloseWithReason).
+	// For the connections to a Shared Web Worker, it also means the worker is closed via WasmSharedWebWorkerMgmtConn.Close.
+	CloseWorkerClosed CloseKind = iota + 1
+	// ClosePortClosed means the Shared Web Worker closed the port of the connection, via SelfSharedConnPort.Close (or CloseWithReason),
+	// while the worker itself keeps running.
+	ClosePortClosed
+	// CloseTerminated means the connection is closed by the controller, e.g. via Terminate or Close.
+	CloseTerminated
+	// CloseExited means the Go program in the worker exited, by returning from main, calling os.Exit or panicing.
+	CloseExited
+	// CloseCrashed means the WASM in the worker crashed without exiting, e.g. due to a WebAssembly trap.
+	CloseCrashed
+)
+
+func (k CloseKind) String() string {
+	switch k {
+	case CloseWorkerClosed:
+		return "worker closed"
+	case ClosePortClosed:
+		return "port closed"
+	case CloseTerminated:
+		return "terminated"
+	case CloseExited:
+		return "exited"
+	case CloseCrashed:
+		return "crashed"
+	default:
+		return "CloseKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// CloseReason describes why a connection is closed, which is available via the CloseReason method of the connection once it is closed.
+type CloseReason struct {
+	Kind CloseKind
+
+	// Code is the code passed to CloseWithReason for CloseWorkerClosed and ClosePortClosed,
+	// or the exit code of the Go program for CloseExited. Otherwise, it is 0.
+	Code int
+
+	// Reason is the reason passed to CloseWithReason for CloseWorkerClosed and ClosePortClosed,
+	// or the error message of the crash for CloseCrashed. Otherwise, it is empty.
+	Reason string
+}
+
+func (r *CloseReason) String() string {
+	if r == nil {
+		return "<nil>"
+	}
+	s := r.Kind.String()
+	if r.Code != 0 {
+		s += fmt.Sprintf(" (code %d)", r.Code)
+	}
+	if r.Reason != "" {
+		s += ": " + r.Reason
+	}
+	return s
+}
+
+// closeMessage is the payload of the close frame sent by the worker, which is JSON encoded.
+// A close frame without payload is the same as the zero value.
+type closeMessage struct {
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// Worker is set when a port of the Shared Web Worker is closed due to the worker closes.
+	Worker bool `json:"worker,omitempty"`
+}
+
+// postCloseFrame posts the close frame, which only carries the msg if it is not the zero value.
+func postCloseFrame(poster MessagePoster, msg closeMessage) error {
+	if msg == (closeMessage{}) {
+		return postControlFrame(poster, frameClose, nil, nil)
+	}
+	return postJSONFrame(poster, frameClose, msg)
+}
+
+// parseCloseFrame parses the payload of the close frame, if any.
+func parseCloseFrame(frame controlFrame) closeMessage {
+	var msg closeMessage
+	if frame.Payload.IsUndefined() || frame.Payload.IsNull() {
+		return msg
+	}
+	frame.unmarshal(&msg)
+	return msg
+}
+
+// parseCrashFrame parses the crash frame sent by the worker bootstrap script, whose payload is the error message.
+func parseCrashFrame(frame controlFrame) (string, bool) {
+	if frame.Kind != frameCrash {
+		return "", false
+	}
+	msg, err := frame.Payload.String()
+	if err != nil {
+		return "", false
+	}
+	return msg, true
+}
@@ -0,0 +1,168 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hack-pad/safejs"
+	"github.com/magodo/go-webworkers/types"
+)
+
+// Codec encodes Go values into the message that is posted to the peer, and decodes the received message data back to Go values.
+// It is used by the Send and Receive methods of the connections.
+type Codec interface {
+	// Marshal encodes v into a message, optionally along with the objects whose ownership are transferred with the message.
+	Marshal(v any) (message safejs.Value, transfers []safejs.Value, err error)
+
+	// Unmarshal decodes the message data into v, which must be a pointer.
+	Unmarshal(data safejs.Value, v any) error
+}
+
+var (
+	// JSONCodec encodes the value as a JSON string.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec encodes the value as a gob stream, which is sent as an Uint8Array with its underlying buffer transferred.
+	// Each message is a self-contained gob stream, which carries its own type information.
+	GobCodec Codec = gobCodec{}
+
+	// BytesCodec sends the raw bytes as an Uint8Array with its underlying buffer transferred.
+	// It only accepts a []byte to Marshal, and a *[]byte to Unmarshal.
+	BytesCodec Codec = bytesCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) (safejs.Value, []safejs.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return safejs.Null(), nil, err
+	}
+	msg, err := safejs.ValueOf(string(b))
+	if err != nil {
+		return safejs.Null(), nil, err
+	}
+	return msg, nil, nil
+}
+
+func (jsonCodec) Unmarshal(data safejs.Value, v any) error {
+	str, err := data.String()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(str), v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) (safejs.Value, []safejs.Value, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return safejs.Null(), nil, err
+	}
+	return bytesToTransferable(buf.Bytes())
+}
+
+func (gobCodec) Unmarshal(data safejs.Value, v any) error {
+	b, err := bytesFromJS(data)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Marshal(v any) (safejs.Value, []safejs.Value, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return safejs.Null(), nil, fmt.Errorf("wasmww: BytesCodec expects []byte to marshal, got %T", v)
+	}
+	return bytesToTransferable(b)
+}
+
+func (bytesCodec) Unmarshal(data safejs.Value, v any) error {
+	p, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("wasmww: BytesCodec expects *[]byte to unmarshal, got %T", v)
+	}
+	b, err := bytesFromJS(data)
+	if err != nil {
+		return err
+	}
+	*p = b
+	return nil
+}
+
+// bytesToJS copies the bytes into a new Uint8Array.
+func bytesToJS(b []byte) (safejs.Value, error) {
+	arr, err := safejs.MustGetGlobal("Uint8Array").New(len(b))
+	if err != nil {
+		return safejs.Null(), err
+	}
+	if _, err := safejs.CopyBytesToJS(arr, b); err != nil {
+		return safejs.Null(), err
+	}
+	return arr, nil
+}
+
+// bytesToTransferable copies the bytes into a new Uint8Array, and returns it together with its underlying buffer as the transfers.
+func bytesToTransferable(b []byte) (safejs.Value, []safejs.Value, error) {
+	arr, err := bytesToJS(b)
+	if err != nil {
+		return safejs.Null(), nil, err
+	}
+	buf, err := arr.Get("buffer")
+	if err != nil {
+		return safejs.Null(), nil, err
+	}
+	return arr, []safejs.Value{buf}, nil
+}
+
+// bytesFromJS copies the bytes out of an Uint8Array.
+func bytesFromJS(v safejs.Value) ([]byte, error) {
+	l, err := v.Length()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	if _, err := safejs.CopyBytesToGo(b, v); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func codecOrDefault(codec Codec) Codec {
+	if codec == nil {
+		return JSONCodec
+	}
+	return codec
+}
+
+// send encodes v with the codec, and posts it via the poster.
+func send(poster MessagePoster, codec Codec, v any) error {
+	msg, transfers, err := codecOrDefault(codec).Marshal(v)
+	if err != nil {
+		return err
+	}
+	return poster.PostMessage(msg, transfers)
+}
+
+// receive receives the next event from the channel, and decodes its data with the codec into v.
+// It returns io.EOF if the channel is closed.
+func receive(ch <-chan types.MessageEventMessage, codec Codec, v any) error {
+	event, ok := <-ch
+	if !ok {
+		return io.EOF
+	}
+	data, err := event.Data()
+	if err != nil {
+		return err
+	}
+	return codecOrDefault(codec).Unmarshal(data, v)
+}
@@ -0,0 +1,111 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type codecMessage struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+func TestCodec(t *testing.T) {
+	cases := map[string]struct {
+		codec Codec
+		in    any
+		// out returns a pointer to the zero value to unmarshal into.
+		out func() any
+	}{
+		"json": {
+			codec: JSONCodec,
+			in:    codecMessage{Name: "a", Count: 1, Tags: []string{"x", "y"}},
+			out:   func() any { return &codecMessage{} },
+		},
+		"gob": {
+			codec: GobCodec,
+			in:    codecMessage{Name: "b", Count: 2, Tags: []string{"z"}},
+			out:   func() any { return &codecMessage{} },
+		},
+		"bytes": {
+			codec: BytesCodec,
+			in:    []byte("\x00\xff日本語"),
+			out:   func() any { return &[]byte{} },
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			msg, _, err := c.codec.Marshal(c.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := c.out()
+			if err := c.codec.Unmarshal(msg, out); err != nil {
+				t.Fatal(err)
+			}
+			if got := reflect.ValueOf(out).Elem().Interface(); !reflect.DeepEqual(got, c.in) {
+				t.Errorf("got %#v, want %#v", got, c.in)
+			}
+		})
+	}
+
+	t.Run("bytes type mismatch", func(t *testing.T) {
+		if _, _, err := BytesCodec.Marshal("not bytes"); err == nil {
+			t.Error("expect Marshal to fail with a non []byte")
+		}
+		msg, _, err := BytesCodec.Marshal([]byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s string
+		if err := BytesCodec.Unmarshal(msg, &s); err == nil {
+			t.Error("expect Unmarshal to fail with a non *[]byte")
+		}
+	})
+}
+
+func TestWasmWebWorkerConnSendReceive(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{Codec: GobCodec}
+	w := startJSConn(t, workers, conn)
+
+	in := codecMessage{Name: "controller", Count: 1}
+	if err := conn.Send(in); err != nil {
+		t.Fatal(err)
+	}
+	var got codecMessage
+	if err := GobCodec.Unmarshal(w.nextMessage(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("the worker got %#v, want %#v", got, in)
+	}
+
+	reply := codecMessage{Name: "worker", Count: 2, Tags: []string{"reply"}}
+	if err := send(w, GobCodec, reply); err != nil {
+		t.Fatal(err)
+	}
+	got = codecMessage{}
+	if err := waitTimeout(t, func() error { return conn.Receive(&got) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, reply) {
+		t.Errorf("the controller got %#v, want %#v", got, reply)
+	}
+
+	// A message that can't be decoded by the Codec fails the Receive.
+	w.post("not a gob stream")
+	if err := waitTimeout(t, func() error { return conn.Receive(&got) }); err == nil {
+		t.Error("expect Receive to fail with the undecodable message")
+	}
+
+	w.postFrame(frameExit, 0)
+	if err := waitTimeout(t, func() error { return conn.Receive(&got) }); !errors.Is(err, io.EOF) {
+		t.Errorf("expect io.EOF once the worker exits, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/magodo/go-webworkers/types"
+)
+
+// MessageConn is a connection that is able to post messages to, and receive events from the peer.
+// It is the common part of Conn and PeerConn.
+type MessageConn interface {
+	MessagePoster
+	EventChannel() <-chan types.MessageEventMessage
+}
+
+// Conn is the controller side connection to a worker, which is implemented by WasmWebWorkerConn and WasmSharedWebWorkerConn.
+type Conn interface {
+	MessageConn
+
+	// Send encodes v with the Codec of the connection, and sends it to the worker.
+	Send(v any) error
+	// Receive receives the next event sent from the worker, and decodes it with the Codec of the connection into v.
+	Receive(v any) error
+	// Call calls the RPC method registered in the worker via PeerConn.Handle, and waits for the reply.
+	Call(ctx context.Context, method string, args any, reply any) error
+	// Transport returns an http.RoundTripper that sends the requests to the handler served in the worker via PeerConn.ServeHTTP.
+	Transport() http.RoundTripper
+	// Close closes the connection.
+	Close() error
+	// Wait waits for the connection to be closed, by either side.
+	Wait() error
+	// CloseReason returns why the connection is closed, or nil if it is not closed yet.
+	CloseReason() *CloseReason
+}
+
+// PeerConn is the worker side connection to the controller, which is implemented by SelfConn and SelfSharedConnPort.
+type PeerConn interface {
+	MessageConn
+
+	// SetupConn sets up the connection with the peering Conn, and returns the channel of events sent from it.
+	SetupConn() (<-chan types.MessageEventMessage, error)
+	// Send encodes v with the Codec of the connection, and sends it to the controller.
+	Send(v any) error
+	// Receive receives the next event sent from the controller, and decodes it with the Codec of the connection into v.
+	Receive(v any) error
+	// Handle registers the handler for the RPC method, which is called by Conn.Call.
+	Handle(method string, handler RPCHandlerFunc)
+	// ServeHTTP serves the HTTP requests sent via Conn.Transport with the handler.
+	ServeHTTP(handler http.Handler)
+	// Close closes the connection.
+	Close() error
+}
+
+var (
+	_ Conn     = &WasmWebWorkerConn{}
+	_ Conn     = &WasmSharedWebWorkerConn{}
+	_ PeerConn = &SelfConn{}
+	_ PeerConn = &SelfSharedConnPort{}
+)
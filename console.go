@@ -0,0 +1,76 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"errors"
+	"syscall/js"
+
+	"github.com/hack-pad/safejs"
+)
+
+// captureConsoleVar is the JS global function defined by the worker bootstrap script, which wraps the console methods of the worker,
+// and forwards the formatted messages to the Go function passed to it, until the Go program exits.
+const captureConsoleVar = "__wasmww_capture_console__"
+
+// consoleOriginVar is the JS global object set once the console is captured, which holds the original console methods.
+const consoleOriginVar = "__wasmww_console_origin__"
+
+// consoleMessage is the payload of the console frame.
+type consoleMessage struct {
+	// Level is the name of the console method, i.e. one of "debug", "log", "info", "warn" and "error".
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// captureConsole wraps the console methods of the worker, and forwards the messages to where the stdout/stderr is redirected to:
+// they are sent to the controller via the poster, and/or written to the console via the original method of the same level.
+// The messages are dropped if the output is discarded. If the output is only written to the io.Writers, the messages are sent to the controller.
+func captureConsole(poster MessagePoster) error {
+	capture := js.Global().Get(captureConsoleVar)
+	if capture.Type() != js.TypeFunction {
+		return errors.New("wasmww: console capturing is not supported by the worker bootstrap script")
+	}
+	post := js.FuncOf(func(this js.Value, args []js.Value) any {
+		msg := consoleMessage{Level: args[0].String(), Message: args[1].String()}
+		controller, console, discard := outputTargets()
+		if console {
+			consoleWrite(msg.Level, msg.Message)
+		}
+		if controller || (!console && !discard) {
+			postJSONFrame(poster, frameConsole, msg)
+		}
+		return nil
+	})
+	_, err := safejs.Safe(capture).Invoke(post)
+	return err
+}
+
+// consoleOutput returns the output line of the console message, and the file descriptor it is written to on the controller,
+// which is 2 (stderr) for the "warn" and "error" levels, and 1 (stdout) for the others.
+func consoleOutput(frame controlFrame) (fd int, line []byte, ok bool) {
+	var msg consoleMessage
+	if err := frame.unmarshal(&msg); err != nil {
+		return 0, nil, false
+	}
+	fd = 1
+	if msg.Level == "warn" || msg.Level == "error" {
+		fd = 2
+	}
+	return fd, []byte(msg.Message + "\n"), true
+}
+
+// consoleLog writes the message via the original console.log, so that the output of the Go program written to the console
+// (e.g. in the tee mode, which also sends it to the controller) isn't captured and sent to the controller again.
+func consoleLog(msg string) {
+	consoleWrite("log", msg)
+}
+
+// consoleWrite writes the message via the original console method of the level, if the console is captured, otherwise, via the console method.
+func consoleWrite(level, msg string) {
+	console := js.Global().Get(consoleOriginVar)
+	if console.Type() != js.TypeObject {
+		console = js.Global().Get("console")
+	}
+	console.Call(level, msg)
+}
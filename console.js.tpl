@@ -0,0 +1,37 @@
+// Wrap the console methods to forward the formatted messages via the post function instead, which is called by the Go program to capture the console output.
+// As the post function is a Go function, the messages are written to the console as usual once the Go program exits.
+// The original methods are exposed to the Go program, which writes its own output to the console via them (e.g. in the tee mode), so that it isn't captured.
+self["{{.CaptureConsoleVar}}"] = (post) => {
+    const format = (arg) => {
+        if (typeof arg === "string") {
+            return arg;
+        }
+        if (arg instanceof Error) {
+            return arg.stack || String(arg);
+        }
+        try {
+            const json = JSON.stringify(arg);
+            if (json !== undefined) {
+                return json;
+            }
+        } catch (err) {
+        }
+        return String(arg);
+    };
+    const origins = {};
+    for (const level of ["debug", "log", "info", "warn", "error"]) {
+        const origin = console[level];
+        origins[level] = origin.bind(console);
+        console[level] = (...args) => {
+            if (!exited) {
+                try {
+                    post(level, args.map(format).join(" "));
+                    return;
+                } catch (err) {
+                }
+            }
+            origin.apply(console, args);
+        };
+    }
+    self["{{.ConsoleOriginVar}}"] = origins;
+};
@@ -0,0 +1,181 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"bytes"
+	"strings"
+	"syscall/js"
+	"testing"
+	"text/template"
+
+	"github.com/hack-pad/safejs"
+)
+
+// setupCaptureConsole evaluates the console partial of the bootstrap scripts, with the console methods replaced by the functions recording the messages
+// in form of "<level>:<message>", which are restored once the test ends.
+func setupCaptureConsole(t *testing.T) *[]string {
+	t.Helper()
+	var script bytes.Buffer
+	tpl := template.Must(template.New("console").Parse(string(ConsoleJSTpl)))
+	if err := tpl.Execute(&script, templateData{CaptureConsoleVar: captureConsoleVar, ConsoleOriginVar: consoleOriginVar}); err != nil {
+		t.Fatal(err)
+	}
+	fn, err := safejs.Safe(js.Global().Get("Function")).New("self", "let exited = false;\n"+script.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fn.Invoke(safejs.Safe(js.Global())); err != nil {
+		t.Fatal(err)
+	}
+
+	console := js.Global().Get("console")
+	origins := map[string]js.Value{}
+	for _, level := range []string{"debug", "log", "info", "warn", "error"} {
+		origins[level] = console.Get(level)
+	}
+	var logged []string
+	var records []js.Func
+	for level := range origins {
+		level := level
+		record := js.FuncOf(func(this js.Value, args []js.Value) any {
+			logged = append(logged, level+":"+args[0].String())
+			return nil
+		})
+		console.Set(level, record)
+		records = append(records, record)
+	}
+	t.Cleanup(func() {
+		for level, origin := range origins {
+			console.Set(level, origin)
+		}
+		for _, record := range records {
+			record.Release()
+		}
+		js.Global().Delete(captureConsoleVar)
+		js.Global().Delete(consoleOriginVar)
+	})
+	return &logged
+}
+
+// useWriteSync makes the s the active "writeSync" during the test, without replacing the "writeSync" of the test binary.
+func useWriteSync(t *testing.T, s *writeSyncer) {
+	activeWriteSyncMu.Lock()
+	defer activeWriteSyncMu.Unlock()
+	origin := activeWriteSync
+	activeWriteSync = s
+	t.Cleanup(func() {
+		activeWriteSyncMu.Lock()
+		defer activeWriteSyncMu.Unlock()
+		activeWriteSync = origin
+	})
+}
+
+func TestCaptureConsole(t *testing.T) {
+	logged := setupCaptureConsole(t)
+	poster := &recordPoster{}
+	useWriteSync(t, newWriteSyncer([]MsgWriter{&msgWriterController{poster: poster, kind: frameStdout}}, nil, WriteSyncOptions{}))
+	if err := captureConsole(poster); err != nil {
+		t.Fatal(err)
+	}
+
+	console := js.Global().Get("console")
+	console.Call("log", "hello", 1, map[string]any{"a": true})
+	console.Call("warn", "oops")
+	// The Go output written to the console, e.g. in the tee mode, is not captured.
+	if _, err := NewMsgWriterToConsole().Write([]byte("go output\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []consoleMessage
+	for _, frame := range poster.frames(t) {
+		if frame.Kind != frameConsole {
+			t.Fatalf("unexpected frame kind %s", frame.Kind)
+		}
+		var msg consoleMessage
+		if err := frame.unmarshal(&msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg)
+	}
+	want := []consoleMessage{
+		{Level: "log", Message: `hello 1 {"a":true}`},
+		{Level: "warn", Message: "oops"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got the captured messages %v, want %v", got, want)
+	}
+	if len(*logged) != 1 || (*logged)[0] != "log:go output" {
+		t.Errorf("expect only the Go output to be written to the console, got %q", *logged)
+	}
+}
+
+func TestCaptureConsoleRedirect(t *testing.T) {
+	cases := map[string]struct {
+		// writers returns the MsgWriters of the stdout, or nil for the "writeSync" of the Go glue file.
+		writers func(poster MessagePoster) []MsgWriter
+		// wantFrames and wantLogged are the captured messages sent to the controller and written to the console respectively.
+		wantFrames []string
+		wantLogged []string
+	}{
+		"console": {
+			wantLogged: []string{"warn:oops", "debug:details"},
+		},
+		"controller": {
+			writers: func(poster MessagePoster) []MsgWriter {
+				return []MsgWriter{&msgWriterController{poster: poster, kind: frameStdout}}
+			},
+			wantFrames: []string{"warn:oops", "debug:details"},
+		},
+		"discard": {
+			writers: func(poster MessagePoster) []MsgWriter {
+				return []MsgWriter{}
+			},
+		},
+		"tee": {
+			writers: func(poster MessagePoster) []MsgWriter {
+				return []MsgWriter{&msgWriterController{poster: poster, kind: frameStdout}, NewMsgWriterToConsole()}
+			},
+			wantFrames: []string{"warn:oops", "debug:details"},
+			wantLogged: []string{"warn:oops", "debug:details"},
+		},
+		"io writer": {
+			writers: func(poster MessagePoster) []MsgWriter {
+				return []MsgWriter{NewMsgWriterToIoWriter(&bytes.Buffer{})}
+			},
+			wantFrames: []string{"warn:oops", "debug:details"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			logged := setupCaptureConsole(t)
+			poster := &recordPoster{}
+			if c.writers != nil {
+				useWriteSync(t, newWriteSyncer(c.writers(poster), nil, WriteSyncOptions{}))
+			} else {
+				useWriteSync(t, nil)
+			}
+			if err := captureConsole(poster); err != nil {
+				t.Fatal(err)
+			}
+			console := js.Global().Get("console")
+			console.Call("warn", "oops")
+			console.Call("debug", "details")
+
+			var frames []string
+			for _, frame := range poster.frames(t) {
+				var msg consoleMessage
+				if err := frame.unmarshal(&msg); err != nil {
+					t.Fatal(err)
+				}
+				frames = append(frames, msg.Level+":"+msg.Message)
+			}
+			if strings.Join(frames, "|") != strings.Join(c.wantFrames, "|") {
+				t.Errorf("got the messages sent to the controller %q, want %q", frames, c.wantFrames)
+			}
+			if strings.Join(*logged, "|") != strings.Join(c.wantLogged, "|") {
+				t.Errorf("got the messages written to the console %q, want %q", *logged, c.wantLogged)
+			}
+		})
+	}
+}
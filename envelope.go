@@ -0,0 +1,160 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"encoding/json"
+
+	"github.com/hack-pad/safejs"
+	"github.com/magodo/go-webworkers/types"
+)
+
+// ProtocolVersion is the version of the wire protocol between the controller and the worker.
+// It is carried by every control frame, and a control frame of another version is dropped by the receiver.
+const ProtocolVersion = 1
+
+// envelopeMarker is the property of a control frame object, whose value is the ProtocolVersion.
+// Any message that isn't an object with this property is a user message, which is delivered to the event channel untouched.
+const envelopeMarker = "__wasmww__"
+
+// The magic strings of the control messages before the control frames are introduced.
+// They are kept for compatibility, but no longer sent nor recognized by this package.
+const (
+	// Deprecated: The close message is sent as a control frame, see ProtocolVersion.
+	CLOSE_EVENT = "__WASMWW_CLOSE__"
+	// Deprecated: The stdout is sent as a control frame, see ProtocolVersion.
+	STDOUT_EVENT = "__WASMWW_STDOUT__"
+	// Deprecated: The stderr is sent as a control frame, see ProtocolVersion.
+	STDERR_EVENT = "__WASMWW_STDERR__"
+	// Deprecated: Use the SetWriteToConsole method of the connections, which sends a control frame.
+	WRITE_TO_CONSOLE_EVENT = "__WASMWW_WRITE_TO_CONSOLE__"
+	// Deprecated: Use the SetWriteToController method of the connections, which sends a control frame.
+	WRITE_TO_CONTROLLER_EVENT = "__WASMWW_WRITE_TO_CONTROLLER"
+)
+
+// frameKind is the kind of a control frame, which determines the type of its payload.
+type frameKind string
+
+const (
+	// frameClose is sent by either side to close the connection.
+	// The payload of the one sent by the worker is an optional JSON encoded closeMessage, otherwise, no payload.
+	frameClose frameKind = "close"
+	// frameExit is sent by the worker bootstrap script when the Go program exits. The payload is the exit code.
+	frameExit frameKind = "exit"
+	// frameModule is sent to the worker right after it is created. The payload is the compiled WebAssembly.Module, see Module.
+	frameModule frameKind = "module"
+	// frameCrash is sent by the worker bootstrap script when the WASM crashes without exiting. The payload is the error message.
+	frameCrash frameKind = "crash"
+	// frameStartError is sent by the worker bootstrap script when it fails to start the WASM. The payload is a JSON encoded StartError.
+	frameStartError frameKind = "start-error"
+	// frameStdout and frameStderr carry the stdout/stderr of the worker. The payload is an Uint8Array, whose buffer is transferred.
+	frameStdout frameKind = "stdout"
+	frameStderr frameKind = "stderr"
+	// frameWriteToConsole, frameWriteToController, frameWriteToDiscard and frameWriteToTee instruct the worker where to write its stdout/stderr.
+	// No payload.
+	frameWriteToConsole    frameKind = "write-to-console"
+	frameWriteToController frameKind = "write-to-controller"
+	frameWriteToDiscard    frameKind = "write-to-discard"
+	frameWriteToTee        frameKind = "write-to-tee"
+	// frameStdin carries the stdin data sent to the worker, and frameStdinEOF closes the worker's stdin.
+	// The payload of frameStdin is an Uint8Array, whose buffer is transferred.
+	frameStdin    frameKind = "stdin"
+	frameStdinEOF frameKind = "stdin-eof"
+	// frameRPCRequest, frameRPCResponse and frameRPCCancel carry the JSON encoded rpcRequest, rpcResponse and rpcCancel respectively.
+	frameRPCRequest  frameKind = "rpc-request"
+	frameRPCResponse frameKind = "rpc-response"
+	frameRPCCancel   frameKind = "rpc-cancel"
+	// frameHTTP carries the JSON encoded httpFrame.
+	frameHTTP frameKind = "http"
+	// frameLog carries the JSON encoded logRecord, which is sent by the slog.Handler in the worker.
+	frameLog frameKind = "log"
+	// frameConsole carries the JSON encoded consoleMessage, which is captured from the console of the worker.
+	frameConsole frameKind = "console"
+	// frameNetConnEOF signals EOF to the peering net.Conn. No payload.
+	// Unlike the other frames, it is delivered to the event channel, which is consumed by the net.Conn.
+	frameNetConnEOF frameKind = "netconn-eof"
+)
+
+// controlFrame is the envelope of the control traffic, which is a JS object in form of:
+//
+//	{"__wasmww__": <ProtocolVersion>, "kind": <frameKind>, "payload": <payload>}
+type controlFrame struct {
+	Kind    frameKind
+	Payload safejs.Value
+}
+
+// newControlFrame builds the JS object of the control frame. The payload is either a safejs.Value, or any value accepted by js.ValueOf.
+func newControlFrame(kind frameKind, payload any) (safejs.Value, error) {
+	if v, ok := payload.(safejs.Value); ok {
+		payload = safejs.Unsafe(v)
+	}
+	return safejs.ValueOf(map[string]any{
+		envelopeMarker: ProtocolVersion,
+		"kind":         string(kind),
+		"payload":      payload,
+	})
+}
+
+// postControlFrame posts the control frame via the poster, optionally transferring ownership of all items in transfers.
+func postControlFrame(poster MessagePoster, kind frameKind, payload any, transfers []safejs.Value) error {
+	frame, err := newControlFrame(kind, payload)
+	if err != nil {
+		return err
+	}
+	return poster.PostMessage(frame, transfers)
+}
+
+// postJSONFrame posts the control frame, whose payload is v encoded as a JSON string.
+func postJSONFrame(poster MessagePoster, kind frameKind, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return postControlFrame(poster, kind, string(b), nil)
+}
+
+// parseControlFrame tells whether the message data is a control frame.
+// A control frame of another protocol version is returned with an empty kind, which is expected to be dropped.
+func parseControlFrame(data safejs.Value) (controlFrame, bool) {
+	if data.Type() != safejs.TypeObject {
+		return controlFrame{}, false
+	}
+	marker, err := data.Get(envelopeMarker)
+	if err != nil || marker.IsUndefined() {
+		return controlFrame{}, false
+	}
+	if version, err := marker.Int(); err != nil || version != ProtocolVersion {
+		return controlFrame{}, true
+	}
+	kind, err := data.Get("kind")
+	if err != nil || kind.Type() != safejs.TypeString {
+		return controlFrame{}, true
+	}
+	kindStr, err := kind.String()
+	if err != nil {
+		return controlFrame{}, true
+	}
+	payload, err := data.Get("payload")
+	if err != nil {
+		return controlFrame{}, true
+	}
+	return controlFrame{Kind: frameKind(kindStr), Payload: payload}, true
+}
+
+// eventControlFrame tells whether the message event is a control frame.
+func eventControlFrame(event types.MessageEventMessage) (controlFrame, bool) {
+	data, err := event.Data()
+	if err != nil {
+		return controlFrame{}, false
+	}
+	return parseControlFrame(data)
+}
+
+// unmarshal decodes the JSON encoded payload into v.
+func (f controlFrame) unmarshal(v any) error {
+	str, err := f.Payload.String()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(str), v)
+}
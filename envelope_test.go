@@ -0,0 +1,110 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"syscall/js"
+	"testing"
+
+	"github.com/hack-pad/safejs"
+)
+
+func TestControlFrame(t *testing.T) {
+	payload, transfers, err := bytesToTransferable([]byte("\x00\xffdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poster := &recordPoster{}
+	if err := postControlFrame(poster, frameStdout, payload, transfers); err != nil {
+		t.Fatal(err)
+	}
+	if err := postControlFrame(poster, frameStdinEOF, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := postControlFrame(poster, frameExit, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := postJSONFrame(poster, frameRPCRequest, rpcRequest{ID: 1, Method: "m", Params: []byte(`{"a":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := poster.frames(t)
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(frames))
+	}
+	if frames[0].Kind != frameStdout {
+		t.Errorf("got kind %s, want %s", frames[0].Kind, frameStdout)
+	}
+	if b, err := bytesFromJS(frames[0].Payload); err != nil || string(b) != "\x00\xffdata" {
+		t.Errorf("got the stdout payload %q (%v)", b, err)
+	}
+	if frames[1].Kind != frameStdinEOF || !frames[1].Payload.IsNull() {
+		t.Errorf("got the %s frame with payload %v, want null", frames[1].Kind, frames[1].Payload)
+	}
+	if state, ok := parseExitFrame(frames[2]); !ok || !state.Exited() || state.ExitCode() != 3 {
+		t.Errorf("got the exit state %v (%t), want exit status 3", state, ok)
+	}
+	var req rpcRequest
+	if err := frames[3].unmarshal(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != 1 || req.Method != "m" || string(req.Params) != `{"a":1}` {
+		t.Errorf("got the rpc request %+v", req)
+	}
+}
+
+func TestParseControlFrame(t *testing.T) {
+	object := func(props map[string]any) safejs.Value {
+		return safejs.Safe(js.ValueOf(props))
+	}
+	cases := []struct {
+		name    string
+		data    safejs.Value
+		isFrame bool
+		kind    frameKind
+	}{
+		{name: "null", data: safejs.Null()},
+		{name: "string", data: safejs.Safe(js.ValueOf("close"))},
+		{name: "number", data: safejs.Safe(js.ValueOf(1))},
+		{name: "user object", data: object(map[string]any{"kind": "close"})},
+		{name: "frame", data: object(map[string]any{envelopeMarker: ProtocolVersion, "kind": "close"}), isFrame: true, kind: frameClose},
+		// The frames of another protocol version, or malformed, are recognized but dropped with an empty kind.
+		{name: "other version", data: object(map[string]any{envelopeMarker: ProtocolVersion + 1, "kind": "close"}), isFrame: true},
+		{name: "non-numeric version", data: object(map[string]any{envelopeMarker: "1", "kind": "close"}), isFrame: true},
+		{name: "non-string kind", data: object(map[string]any{envelopeMarker: ProtocolVersion, "kind": 1}), isFrame: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			frame, ok := parseControlFrame(c.data)
+			if ok != c.isFrame {
+				t.Fatalf("got isFrame %t, want %t", ok, c.isFrame)
+			}
+			if frame.Kind != c.kind {
+				t.Errorf("got kind %q, want %q", frame.Kind, c.kind)
+			}
+		})
+	}
+}
+
+func TestCloseFrame(t *testing.T) {
+	poster := &recordPoster{}
+	msgs := []closeMessage{{}, {Code: 4000, Reason: "bye \"\n"}, {Worker: true}}
+	for _, msg := range msgs {
+		if err := postCloseFrame(poster, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The close frame sent by the controller has no payload.
+	if err := postControlFrame(poster, frameClose, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	frames := poster.frames(t)
+	for i, msg := range append(msgs, closeMessage{}) {
+		if frames[i].Kind != frameClose {
+			t.Errorf("frame %d: got kind %s, want %s", i, frames[i].Kind, frameClose)
+		}
+		if got := parseCloseFrame(frames[i]); got != msg {
+			t.Errorf("frame %d: got %+v, want %+v", i, got, msg)
+		}
+	}
+}
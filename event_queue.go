@@ -0,0 +1,62 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"sync"
+
+	"github.com/magodo/go-webworkers/types"
+)
+
+// eventQueue delivers the events to its channel in order, from its own goroutine. Pushing to it never blocks,
+// so that the relay of the control frames (e.g. the stdin, RPC and HTTP frames) isn't stalled by a consumer that doesn't drain the channel.
+type eventQueue struct {
+	ch chan types.MessageEventMessage
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []types.MessageEventMessage
+	closed bool
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{ch: make(chan types.MessageEventMessage)}
+	q.cond = sync.NewCond(&q.mu)
+	go q.deliver()
+	return q
+}
+
+func (q *eventQueue) deliver() {
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.events) == 0 {
+			q.mu.Unlock()
+			close(q.ch)
+			return
+		}
+		event := q.events[0]
+		q.events[0] = types.MessageEventMessage{}
+		q.events = q.events[1:]
+		q.mu.Unlock()
+		q.ch <- event
+	}
+}
+
+// push queues the event to be delivered.
+func (q *eventQueue) push(event types.MessageEventMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.events = append(q.events, event)
+	q.cond.Signal()
+}
+
+// close closes the channel once the queued events are delivered.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Signal()
+}
@@ -74,26 +74,26 @@ func main() {
 	if err := startHandle(conn); err != nil {
 		log.Fatal(err)
 	}
-	if err := conn.PostMessage(safejs.Safe(js.ValueOf("WriteToConsole")), nil); err != nil {
+	if err := conn.SetWriteToConsole(); err != nil {
 		log.Fatal(err)
 	}
 	if err := conn.PostMessage(safejs.Safe(js.ValueOf("Hey Console!")), nil); err != nil {
 		log.Fatal(err)
 	}
-	if err := conn.PostMessage(safejs.Safe(js.ValueOf("WriteToController")), nil); err != nil {
+	if err := conn.SetWriteToController(); err != nil {
 		log.Fatal(err)
 	}
 	if err := conn.PostMessage(safejs.Safe(js.ValueOf("Hey Controller!")), nil); err != nil {
 		log.Fatal(err)
 	}
-	if err := conn.PostMessage(safejs.Safe(js.ValueOf("WriteToNull")), nil); err != nil {
+	if err := conn.SetWriteToDiscard(); err != nil {
 		log.Fatal(err)
 	}
 	if err := conn.PostMessage(safejs.Safe(js.ValueOf("Hey Secret!")), nil); err != nil {
 		log.Fatal(err)
 	}
 	// Reset the writer before close
-	if err := conn.PostMessage(safejs.Safe(js.ValueOf("WriteToController")), nil); err != nil {
+	if err := conn.SetWriteToController(); err != nil {
 		log.Fatal(err)
 	}
 	if err := conn.PostMessage(safejs.Safe(js.ValueOf("Close")), nil); err != nil {
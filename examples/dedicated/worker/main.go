@@ -4,7 +4,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"syscall/js"
@@ -31,7 +30,6 @@ func main() {
 	log.Printf("Worker (%s): Args: %v\n", name, os.Args)
 	log.Printf("Worker (%s): Env: %v\n", name, os.Environ())
 
-	null := io.Discard
 	for event := range ch {
 		data, err := event.Data()
 		if err != nil {
@@ -48,21 +46,6 @@ func main() {
 		case "Close":
 			fmt.Printf("Worker (%s): Close\n", name)
 			self.Close()
-		case "WriteToConsole":
-			self.ResetWriteSync()
-			continue
-		case "WriteToNull":
-			wasmww.SetWriteSync(
-				[]wasmww.MsgWriter{wasmww.NewMsgWriterToIoWriter(null)},
-				[]wasmww.MsgWriter{wasmww.NewMsgWriterToIoWriter(null)},
-			)
-			continue
-		case "WriteToController":
-			wasmww.SetWriteSync(
-				[]wasmww.MsgWriter{self.NewMsgWriterToControllerStdout()},
-				[]wasmww.MsgWriter{self.NewMsgWriterToControllerStderr()},
-			)
-			continue
 		}
 
 		fmt.Printf("Worker (%s): Received message %q\n", name, str)
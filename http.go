@@ -0,0 +1,416 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// ErrHTTPConnClosed is returned by the RoundTrip of the Transport, and by the reading of the response body,
+// when the connection is closed before the HTTP exchange completes.
+var ErrHTTPConnClosed = errors.New("wasmww: http: connection closed")
+
+// httpChunkSize is the maximum size of the body data carried by one frame.
+const httpChunkSize = 32 * 1024
+
+type httpFrameKind string
+
+const (
+	httpFrameRequest      httpFrameKind = "request"
+	httpFrameRequestBody  httpFrameKind = "request-body"
+	httpFrameRequestEnd   httpFrameKind = "request-end"
+	httpFrameResponse     httpFrameKind = "response"
+	httpFrameResponseBody httpFrameKind = "response-body"
+	httpFrameResponseEnd  httpFrameKind = "response-end"
+	httpFrameCancel       httpFrameKind = "cancel"
+)
+
+// httpFrame is one frame of an HTTP exchange. The request and response bodies are streamed as a sequence of body frames, followed by an end frame.
+type httpFrame struct {
+	ID     uint64        `json:"id"`
+	Kind   httpFrameKind `json:"kind"`
+	Method string        `json:"method,omitempty"`
+	URL    string        `json:"url,omitempty"`
+	Header http.Header   `json:"header,omitempty"`
+	Status int           `json:"status,omitempty"`
+	// ContentLength is the length of the body of the request and response frames, -1 means unknown.
+	ContentLength int64  `json:"contentLength,omitempty"`
+	Data          []byte `json:"data,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+func postHTTPFrame(poster MessagePoster, frame httpFrame) error {
+	return postJSONFrame(poster, frameHTTP, frame)
+}
+
+// postBody streams the body as body frames of the given kind, followed by an end frame, which carries the read error, if any.
+func postBody(poster MessagePoster, id uint64, body io.Reader, bodyKind, endKind httpFrameKind) {
+	end := httpFrame{ID: id, Kind: endKind}
+	if body != nil {
+		buf := make([]byte, httpChunkSize)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if err := postHTTPFrame(poster, httpFrame{ID: id, Kind: bodyKind, Data: buf[:n]}); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					end.Error = err.Error()
+				}
+				break
+			}
+		}
+	}
+	postHTTPFrame(poster, end)
+}
+
+// httpClient tracks the in-flight HTTP exchanges on the controller side.
+type httpClient struct {
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]*httpExchange
+	closed  bool
+}
+
+type httpExchange struct {
+	respCh chan httpFrame
+	body   *bufferedPipe
+}
+
+func newHTTPClient() *httpClient {
+	return &httpClient{pending: map[uint64]*httpExchange{}}
+}
+
+// dispatch delivers the response frame to the in-flight exchange.
+func (c *httpClient) dispatch(cf controlFrame) {
+	var frame httpFrame
+	if err := cf.unmarshal(&frame); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ex, ok := c.pending[frame.ID]
+	if !ok {
+		return
+	}
+	switch frame.Kind {
+	case httpFrameResponse:
+		ex.respCh <- frame
+	case httpFrameResponseBody:
+		ex.body.write(frame.Data)
+	case httpFrameResponseEnd:
+		var err error
+		if frame.Error != "" {
+			err = errors.New(frame.Error)
+		}
+		// The end frame might come without a response frame, when the worker fails to serve the request.
+		select {
+		case ex.respCh <- frame:
+		default:
+		}
+		ex.body.closeWrite(err)
+		delete(c.pending, frame.ID)
+	}
+}
+
+func (c *httpClient) remove(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	return ok
+}
+
+// close fails all the in-flight exchanges, and any further exchange.
+func (c *httpClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for id, ex := range c.pending {
+		close(ex.respCh)
+		ex.body.closeWrite(ErrHTTPConnClosed)
+		delete(c.pending, id)
+	}
+}
+
+func (c *httpClient) roundTrip(poster MessagePoster, req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrHTTPConnClosed
+	}
+	c.nextID++
+	id := c.nextID
+	ex := &httpExchange{
+		respCh: make(chan httpFrame, 1),
+		body:   newBufferedPipe(),
+	}
+	c.pending[id] = ex
+	c.mu.Unlock()
+
+	cancel := func() {
+		if c.remove(id) {
+			postHTTPFrame(poster, httpFrame{ID: id, Kind: httpFrameCancel})
+		}
+	}
+
+	// A zero ContentLength with a body means unknown for the client request, while it means no body for the server request.
+	contentLength := req.ContentLength
+	if contentLength == 0 && req.Body != nil && req.Body != http.NoBody {
+		contentLength = -1
+	}
+	if err := postHTTPFrame(poster, httpFrame{ID: id, Kind: httpFrameRequest, Method: req.Method, URL: req.URL.String(), Header: req.Header, ContentLength: contentLength}); err != nil {
+		c.remove(id)
+		return nil, err
+	}
+	go func() {
+		postBody(poster, id, req.Body, httpFrameRequestBody, httpFrameRequestEnd)
+		if req.Body != nil {
+			req.Body.Close()
+		}
+	}()
+
+	ctx := req.Context()
+	var frame httpFrame
+	select {
+	case f, ok := <-ex.respCh:
+		if !ok {
+			return nil, ErrHTTPConnClosed
+		}
+		frame = f
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+	if frame.Kind == httpFrameResponseEnd {
+		return nil, fmt.Errorf("wasmww: worker failed to serve the request: %s", frame.Error)
+	}
+
+	// Abort the body reading once the request ctx is done.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			ex.body.closeWrite(ctx.Err())
+			cancel()
+		case <-done:
+		}
+	}()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", frame.Status, http.StatusText(frame.Status)),
+		StatusCode:    frame.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        frame.Header,
+		Body:          &httpResponseBody{pipe: ex.body, done: done, cancel: cancel},
+		ContentLength: frame.ContentLength,
+		Request:       req,
+	}, nil
+}
+
+// httpResponseBody notifies the worker to stop serving when it is closed before EOF.
+type httpResponseBody struct {
+	pipe   *bufferedPipe
+	done   chan struct{}
+	cancel func()
+	once   sync.Once
+}
+
+func (b *httpResponseBody) Read(p []byte) (int, error) {
+	return b.pipe.Read(p)
+}
+
+func (b *httpResponseBody) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+		b.cancel()
+		b.pipe.Close()
+	})
+	return nil
+}
+
+// httpTransport is the http.RoundTripper bound to a connection, which sends the requests to the handler served in the worker.
+type httpTransport struct {
+	client func() (*httpClient, MessagePoster)
+}
+
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	client, poster := t.client()
+	if client == nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errors.New("wasmww: connection not started")
+	}
+	return client.roundTrip(poster, req)
+}
+
+// httpServer serves the HTTP requests from the controller with the handler, on the worker side.
+type httpServer struct {
+	mu       sync.Mutex
+	handler  http.Handler
+	inflight map[uint64]*httpServerExchange
+}
+
+type httpServerExchange struct {
+	body   *bufferedPipe
+	cancel context.CancelFunc
+}
+
+func (s *httpServer) setHandler(handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// dispatch serves the request frames.
+func (s *httpServer) dispatch(poster MessagePoster, cf controlFrame) {
+	var frame httpFrame
+	if err := cf.unmarshal(&frame); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch frame.Kind {
+	case httpFrameRequest:
+		s.serve(poster, frame)
+	case httpFrameRequestBody:
+		if ex, ok := s.inflight[frame.ID]; ok {
+			ex.body.write(frame.Data)
+		}
+	case httpFrameRequestEnd:
+		if ex, ok := s.inflight[frame.ID]; ok {
+			var err error
+			if frame.Error != "" {
+				err = errors.New(frame.Error)
+			}
+			ex.body.closeWrite(err)
+		}
+	case httpFrameCancel:
+		if ex, ok := s.inflight[frame.ID]; ok {
+			ex.cancel()
+			ex.body.closeWrite(context.Canceled)
+		}
+	}
+}
+
+// serve starts serving the request in a new goroutine. It must be called with s.mu held.
+func (s *httpServer) serve(poster MessagePoster, frame httpFrame) {
+	if s.handler == nil {
+		postHTTPFrame(poster, httpFrame{ID: frame.ID, Kind: httpFrameResponseEnd, Error: "no HTTP handler served"})
+		return
+	}
+	handler := s.handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body := newBufferedPipe()
+	req, err := http.NewRequestWithContext(ctx, frame.Method, frame.URL, body)
+	if err != nil {
+		cancel()
+		postHTTPFrame(poster, httpFrame{ID: frame.ID, Kind: httpFrameResponseEnd, Error: err.Error()})
+		return
+	}
+	if frame.Header != nil {
+		req.Header = frame.Header
+	}
+	req.ContentLength = frame.ContentLength
+	req.RequestURI = req.URL.RequestURI()
+	req.RemoteAddr = "controller"
+
+	if s.inflight == nil {
+		s.inflight = map[uint64]*httpServerExchange{}
+	}
+	s.inflight[frame.ID] = &httpServerExchange{body: body, cancel: cancel}
+
+	go func() {
+		w := &httpResponseWriter{id: frame.ID, poster: poster, header: http.Header{}}
+		end := httpFrame{ID: frame.ID, Kind: httpFrameResponseEnd}
+		defer func() {
+			if r := recover(); r != nil {
+				end.Error = fmt.Sprintf("handler panics: %v", r)
+			} else {
+				w.writeHeader(http.StatusOK)
+			}
+			postHTTPFrame(poster, end)
+
+			s.mu.Lock()
+			delete(s.inflight, frame.ID)
+			s.mu.Unlock()
+			cancel()
+			body.Close()
+		}()
+		handler.ServeHTTP(w, req)
+	}()
+}
+
+// close cancels all the in-flight requests.
+func (s *httpServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ex := range s.inflight {
+		ex.cancel()
+		ex.body.closeWrite(context.Canceled)
+	}
+}
+
+// httpResponseWriter sends the response header and each write of the body to the controller immediately.
+type httpResponseWriter struct {
+	id          uint64
+	poster      MessagePoster
+	header      http.Header
+	wroteHeader bool
+}
+
+var _ http.Flusher = &httpResponseWriter{}
+
+func (w *httpResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *httpResponseWriter) WriteHeader(statusCode int) {
+	w.writeHeader(statusCode)
+}
+
+func (w *httpResponseWriter) writeHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	// The length of the response body is only known when the handler sets the Content-Length header.
+	contentLength := int64(-1)
+	if v := w.header.Get("Content-Length"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			contentLength = n
+		}
+	}
+	postHTTPFrame(w.poster, httpFrame{ID: w.id, Kind: httpFrameResponse, Status: statusCode, Header: w.header.Clone(), ContentLength: contentLength})
+}
+
+func (w *httpResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.writeHeader(http.StatusOK)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := postHTTPFrame(w.poster, httpFrame{ID: w.id, Kind: httpFrameResponseBody, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op, as each write is sent to the controller immediately.
+func (w *httpResponseWriter) Flush() {}
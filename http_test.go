@@ -0,0 +1,200 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/safejs"
+)
+
+// loopPoster delivers the posted control frames to the dispatch func in order, from its own goroutine.
+type loopPoster struct {
+	ch chan safejs.Value
+}
+
+func newLoopPoster(dispatch func(frame controlFrame)) *loopPoster {
+	p := &loopPoster{ch: make(chan safejs.Value, 1024)}
+	go func() {
+		for msg := range p.ch {
+			if frame, ok := parseControlFrame(msg); ok {
+				dispatch(frame)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *loopPoster) PostMessage(message safejs.Value, transfers []safejs.Value) error {
+	p.ch <- message
+	return nil
+}
+
+// newHTTPLoop connects a httpClient with a httpServer serving the handler.
+func newHTTPLoop(t *testing.T, handler http.Handler) http.RoundTripper {
+	client := newHTTPClient()
+	var server httpServer
+	server.setHandler(handler)
+	toClient := newLoopPoster(client.dispatch)
+	toServer := newLoopPoster(func(frame controlFrame) {
+		server.dispatch(toClient, frame)
+	})
+	t.Cleanup(func() {
+		client.close()
+		server.close()
+	})
+	return &httpTransport{
+		client: func() (*httpClient, MessagePoster) {
+			return client, toServer
+		},
+	}
+}
+
+func TestHTTPContentLength(t *testing.T) {
+	transport := newHTTPLoop(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading the request body: %v", err)
+		}
+		w.Header().Set("X-Request-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		if r.URL.Query().Get("length") == "true" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.Write(body)
+	}))
+
+	cases := []struct {
+		name           string
+		body           io.Reader
+		reqLength      int64
+		respHasLength  bool
+		wantRespLength int64
+	}{
+		{name: "no body", reqLength: 0, wantRespLength: -1},
+		{name: "known length", body: strings.NewReader("hello"), reqLength: 5, wantRespLength: -1},
+		{name: "unknown length", body: io.NopCloser(strings.NewReader("hello")), reqLength: -1, wantRespLength: -1},
+		{name: "response length", body: strings.NewReader("hello"), reqLength: 5, respHasLength: true, wantRespLength: 5},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://worker/echo?length=%t", c.respHasLength), c.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Header.Get("X-Request-Content-Length"); got != strconv.FormatInt(c.reqLength, 10) {
+				t.Errorf("the handler got request ContentLength %s, want %d", got, c.reqLength)
+			}
+			if resp.ContentLength != c.wantRespLength {
+				t.Errorf("got response ContentLength %d, want %d", resp.ContentLength, c.wantRespLength)
+			}
+			if c.body != nil && string(body) != "hello" {
+				t.Errorf("got response body %q, want %q", body, "hello")
+			}
+		})
+	}
+}
+
+func TestHTTPConnClosed(t *testing.T) {
+	client := newHTTPClient()
+	// The requests are never served.
+	poster := newLoopPoster(func(controlFrame) {})
+	transport := &httpTransport{
+		client: func() (*httpClient, MessagePoster) {
+			return client, poster
+		},
+	}
+
+	errCh := make(chan error)
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, "http://worker/", nil)
+		_, err := transport.RoundTrip(req)
+		errCh <- err
+	}()
+	// Wait for the request to be in-flight.
+	for {
+		client.mu.Lock()
+		n := len(client.pending)
+		client.mu.Unlock()
+		if n != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	client.close()
+	if err := <-errCh; !errors.Is(err, ErrHTTPConnClosed) || errors.Is(err, ErrRPCConnClosed) {
+		t.Errorf("expect the in-flight request to fail with ErrHTTPConnClosed, got %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://worker/", nil)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, ErrHTTPConnClosed) {
+		t.Errorf("expect the request after close to fail with ErrHTTPConnClosed, got %v", err)
+	}
+}
+
+func TestHTTPInterleavedWithUndrainedEvents(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{}
+	w := startJSConn(t, workers, conn)
+	var server httpServer
+	server.setHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// The user messages are sent before, in the middle of, and after the response, none of which is consumed.
+		w.post("before")
+		rw.Write([]byte("hello, "))
+		if f, ok := rw.(http.Flusher); ok {
+			f.Flush()
+		}
+		w.post("middle")
+		rw.Write([]byte("world"))
+		w.post("after")
+	}))
+	w.serve(nil, &server)
+
+	client := &http.Client{Transport: conn.Transport(), Timeout: 5 * time.Second}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("http://worker/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "hello, world" {
+			t.Errorf("got body %q, want %q", body, "hello, world")
+		}
+	}
+
+	conn.Terminate()
+	var got []string
+	for event := range conn.EventChannel() {
+		data, err := event.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := data.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, s)
+	}
+	if want := "before,middle,after,before,middle,after"; strings.Join(got, ",") != want {
+		t.Errorf("got events %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,245 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// logRecord is the payload of the log frame, which is the wire format of a slog.Record.
+type logRecord struct {
+	Time    time.Time  `json:"time"`
+	Level   slog.Level `json:"level"`
+	Message string     `json:"msg"`
+	Worker  string     `json:"worker,omitempty"`
+	Attrs   []logAttr  `json:"attrs,omitempty"`
+}
+
+// logAttr is the wire format of a slog.Attr, which keeps the kind of the value.
+type logAttr struct {
+	Key   string          `json:"key"`
+	Kind  string          `json:"kind"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Group []logAttr       `json:"group,omitempty"`
+}
+
+func encodeLogAttrs(attrs []slog.Attr) []logAttr {
+	var out []logAttr
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		la := logAttr{Key: a.Key, Kind: v.Kind().String()}
+		var value any
+		switch v.Kind() {
+		case slog.KindGroup:
+			la.Group = encodeLogAttrs(v.Group())
+		case slog.KindString:
+			value = v.String()
+		case slog.KindInt64:
+			value = v.Int64()
+		case slog.KindUint64:
+			value = v.Uint64()
+		case slog.KindFloat64:
+			value = v.Float64()
+		case slog.KindBool:
+			value = v.Bool()
+		case slog.KindDuration:
+			value = int64(v.Duration())
+		case slog.KindTime:
+			value = v.Time()
+		default:
+			// The value of any other type is sent as JSON if possible, otherwise, as its string representation.
+			value = v.Any()
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+		}
+		if value != nil {
+			b, err := json.Marshal(value)
+			if err != nil {
+				la.Kind = slog.KindString.String()
+				b, _ = json.Marshal(fmt.Sprint(value))
+			}
+			la.Value = b
+		}
+		out = append(out, la)
+	}
+	return out
+}
+
+func decodeLogAttrs(attrs []logAttr) []slog.Attr {
+	var out []slog.Attr
+	for _, la := range attrs {
+		var v slog.Value
+		switch la.Kind {
+		case slog.KindGroup.String():
+			v = slog.GroupValue(decodeLogAttrs(la.Group)...)
+		case slog.KindString.String():
+			var s string
+			json.Unmarshal(la.Value, &s)
+			v = slog.StringValue(s)
+		case slog.KindInt64.String():
+			var i int64
+			json.Unmarshal(la.Value, &i)
+			v = slog.Int64Value(i)
+		case slog.KindUint64.String():
+			var u uint64
+			json.Unmarshal(la.Value, &u)
+			v = slog.Uint64Value(u)
+		case slog.KindFloat64.String():
+			var f float64
+			json.Unmarshal(la.Value, &f)
+			v = slog.Float64Value(f)
+		case slog.KindBool.String():
+			var b bool
+			json.Unmarshal(la.Value, &b)
+			v = slog.BoolValue(b)
+		case slog.KindDuration.String():
+			var d int64
+			json.Unmarshal(la.Value, &d)
+			v = slog.DurationValue(time.Duration(d))
+		case slog.KindTime.String():
+			var t time.Time
+			json.Unmarshal(la.Value, &t)
+			v = slog.TimeValue(t)
+		default:
+			var a any
+			json.Unmarshal(la.Value, &a)
+			v = slog.AnyValue(a)
+		}
+		out = append(out, slog.Attr{Key: la.Key, Value: v})
+	}
+	return out
+}
+
+// logHandler is the slog.Handler used in the worker, which sends the log records to the controller.
+type logHandler struct {
+	poster func() MessagePoster
+	worker string
+	opts   slog.HandlerOptions
+	goas   []groupOrAttrs
+}
+
+// groupOrAttrs is either a group opened by WithGroup, or the attrs added by WithAttrs.
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+var _ slog.Handler = &logHandler{}
+
+func newLogHandler(poster func() MessagePoster, worker string, opts *slog.HandlerOptions) *logHandler {
+	h := &logHandler{poster: poster, worker: worker}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *logHandler) Handle(_ context.Context, r slog.Record) error {
+	poster := h.poster()
+	if poster == nil {
+		return fmt.Errorf("wasmww: log handler used before the connection is set up")
+	}
+
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	// Nest the attrs into the groups, from the innermost outwards.
+	for i := len(h.goas) - 1; i >= 0; i-- {
+		goa := h.goas[i]
+		if goa.group == "" {
+			attrs = append(slices.Clip(goa.attrs), attrs...)
+			continue
+		}
+		// A group without any attrs is omitted.
+		if len(attrs) == 0 {
+			continue
+		}
+		attrs = []slog.Attr{{Key: goa.group, Value: slog.GroupValue(attrs...)}}
+	}
+	if h.opts.ReplaceAttr != nil {
+		attrs = replaceAttrs(h.opts.ReplaceAttr, nil, attrs)
+	}
+
+	return postJSONFrame(poster, frameLog, logRecord{
+		Time:    r.Time,
+		Level:   r.Level,
+		Message: r.Message,
+		Worker:  h.worker,
+		Attrs:   encodeLogAttrs(attrs),
+	})
+}
+
+func replaceAttrs(replace func(groups []string, a slog.Attr) slog.Attr, groups []string, attrs []slog.Attr) []slog.Attr {
+	var out []slog.Attr
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindGroup {
+			a.Value = slog.GroupValue(replaceAttrs(replace, append(slices.Clip(groups), a.Key), a.Value.Group())...)
+		} else {
+			a = replace(groups, a)
+		}
+		// An empty attr is omitted.
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+func (h *logHandler) withGroupOrAttrs(goa groupOrAttrs) *logHandler {
+	h2 := *h
+	h2.goas = append(slices.Clip(h.goas), goa)
+	return &h2
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.withGroupOrAttrs(groupOrAttrs{attrs: attrs})
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.withGroupOrAttrs(groupOrAttrs{group: name})
+}
+
+// handleLogFrame decodes the log frame, and handles the record with the handler, or the default handler if it is nil.
+// The worker name is added to the record as the "worker" attr.
+func handleLogFrame(handler slog.Handler, frame controlFrame) {
+	var rec logRecord
+	if err := frame.unmarshal(&rec); err != nil {
+		return
+	}
+	if handler == nil {
+		handler = slog.Default().Handler()
+	}
+	ctx := context.Background()
+	if !handler.Enabled(ctx, rec.Level) {
+		return
+	}
+	r := slog.NewRecord(rec.Time, rec.Level, rec.Message, 0)
+	if rec.Worker != "" {
+		r.AddAttrs(slog.String("worker", rec.Worker))
+	}
+	r.AddAttrs(decodeLogAttrs(rec.Attrs)...)
+	handler.Handle(ctx, r)
+}
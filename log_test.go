@@ -0,0 +1,108 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogHandler(t *testing.T) {
+	poster := &recordPoster{}
+	h := newLogHandler(func() MessagePoster { return poster }, "w1", &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == "secret" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	ctx := context.Background()
+	if h.Enabled(ctx, slog.LevelDebug-1) {
+		t.Error("expect the level below the Level option to be disabled")
+	}
+
+	r := slog.NewRecord(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), slog.LevelDebug, "hello", 0)
+	r.AddAttrs(
+		slog.Bool("b", true),
+		slog.Duration("d", time.Second),
+		slog.String("secret", "x"),
+		slog.Any("err", errors.New("boom")),
+		slog.Group("empty"),
+	)
+	if err := h.WithAttrs([]slog.Attr{slog.Int("a", 1)}).WithGroup("g").WithGroup("unused").Handle(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	// The record without attrs in a group omits the group.
+	if err := h.WithGroup("g").Handle(ctx, slog.NewRecord(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), slog.LevelWarn, "bare", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	controllerHandler := slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})
+	for _, frame := range poster.frames(t) {
+		if frame.Kind != frameLog {
+			t.Fatalf("unexpected frame kind %s", frame.Kind)
+		}
+		handleLogFrame(controllerHandler, frame)
+	}
+	want := `{"time":"2026-01-02T03:04:05Z","level":"DEBUG","msg":"hello","worker":"w1","a":1,"g":{"unused":{"b":true,"d":1000000000,"err":"boom"}}}
+{"time":"2026-01-02T03:04:05Z","level":"WARN","msg":"bare","worker":"w1"}
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// The records below the level of the controller side handler are dropped.
+	out.Reset()
+	for _, frame := range poster.frames(t) {
+		handleLogFrame(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelWarn}), frame)
+	}
+	if got := strings.Count(out.String(), "\n"); got != 1 {
+		t.Errorf("expect only the warn record to be handled, got %q", out.String())
+	}
+
+	t.Run("before setup", func(t *testing.T) {
+		h := newLogHandler(func() MessagePoster { return nil }, "w1", nil)
+		if err := h.Handle(ctx, r); err == nil {
+			t.Error("expect Handle to fail before the connection is set up")
+		}
+	})
+}
+
+func TestWasmWebWorkerConnLogHandler(t *testing.T) {
+	workers := installJSWorkers(t)
+	logged := make(chan string, 1)
+	conn := &WasmWebWorkerConn{
+		Name: "w1",
+		LogHandler: slog.NewJSONHandler(writerFunc(func(p []byte) (int, error) {
+			logged <- string(p)
+			return len(p), nil
+		}), &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey && len(groups) == 0 {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}),
+	}
+	w := startJSConn(t, workers, conn)
+
+	logger := slog.New(newLogHandler(func() MessagePoster { return w }, conn.Name, nil))
+	logger.Info("from worker", "n", 1)
+	select {
+	case got := <-logged:
+		if want := `{"level":"INFO","msg":"from worker","worker":"w1","n":1}` + "\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the log record")
+	}
+}
@@ -0,0 +1,121 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall/js"
+
+	"github.com/hack-pad/safejs"
+)
+
+// Module is a compiled WebAssembly.Module, which can be shared by many Dedicated Web Workers, so that each worker instantiates it directly,
+// instead of fetching and compiling the WASM again. It is sent to the worker right after the worker is created.
+//
+// A Module can't be used by the Shared Web Workers, as it can't be sent to them.
+type Module struct {
+	value safejs.Value
+}
+
+// CompileModule fetches the WASM from the path, and compiles it into a Module. The path can be a relative path on the server, or an absolute URL.
+// If the fetching or compiling fails, a *StartError is returned.
+func CompileModule(ctx context.Context, path string) (*Module, error) {
+	fetch, err := safejs.Global().Get("fetch")
+	if err != nil {
+		return nil, err
+	}
+	promise, err := fetch.Invoke(path)
+	if err != nil {
+		return nil, &StartError{Stage: StartStageFetch, Message: err.Error()}
+	}
+	resp, err := awaitPromise(ctx, promise)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("fetching the module: %w", ctxErr)
+		}
+		return nil, &StartError{Stage: StartStageFetch, Message: err.Error()}
+	}
+	if jsResp := safejs.Unsafe(resp); !jsResp.Get("ok").Truthy() {
+		return nil, &StartError{Stage: StartStageFetch, Message: fmt.Sprintf("%d %s", jsResp.Get("status").Int(), jsResp.Get("statusText").String())}
+	}
+
+	webAssembly, err := safejs.Global().Get("WebAssembly")
+	if err != nil {
+		return nil, err
+	}
+	promise, err = webAssembly.Call("compileStreaming", resp)
+	if err != nil {
+		return nil, &StartError{Stage: StartStageCompile, Message: err.Error()}
+	}
+	module, err := awaitPromise(ctx, promise)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("compiling the module: %w", ctxErr)
+		}
+		return nil, &StartError{Stage: StartStageCompile, Message: err.Error()}
+	}
+	return &Module{value: module}, nil
+}
+
+// NewModule wraps a WebAssembly.Module that is compiled elsewhere.
+func NewModule(module safejs.Value) (*Module, error) {
+	ctor, err := safejs.Global().Get("WebAssembly")
+	if err == nil {
+		ctor, err = ctor.Get("Module")
+	}
+	if err != nil {
+		return nil, err
+	}
+	ok, err := module.InstanceOf(ctor)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("wasmww: not a WebAssembly.Module")
+	}
+	return &Module{value: module}, nil
+}
+
+// Value returns the underlying WebAssembly.Module.
+func (m *Module) Value() safejs.Value {
+	return m.value
+}
+
+// awaitPromise waits for the promise to settle, until the ctx is done.
+// It returns the fulfilled value, or an error with the rejected reason.
+func awaitPromise(ctx context.Context, promise safejs.Value) (safejs.Value, error) {
+	type result struct {
+		value safejs.Value
+		err   error
+	}
+	ch := make(chan result, 1)
+	// The callbacks are released once the promise settles, which might be after the ctx is done.
+	var onFulfilled, onRejected js.Func
+	release := func() {
+		onFulfilled.Release()
+		onRejected.Release()
+	}
+	onFulfilled = js.FuncOf(func(this js.Value, args []js.Value) any {
+		release()
+		ch <- result{value: safejs.Safe(args[0])}
+		return nil
+	})
+	onRejected = js.FuncOf(func(this js.Value, args []js.Value) any {
+		release()
+		ch <- result{err: errors.New(js.Global().Get("String").Invoke(args[0]).String())}
+		return nil
+	})
+
+	if _, err := promise.Call("then", onFulfilled, onRejected); err != nil {
+		release()
+		return safejs.Value{}, err
+	}
+	select {
+	case r := <-ch:
+		return r.value, r.err
+	case <-ctx.Done():
+		return safejs.Value{}, ctx.Err()
+	}
+}
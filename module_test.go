@@ -0,0 +1,100 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"errors"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/safejs"
+)
+
+// newTestModule compiles an empty WASM into a Module.
+func newTestModule(t *testing.T) *Module {
+	t.Helper()
+	wasm := js.Global().Get("Uint8Array").New(js.ValueOf([]any{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}))
+	module, err := NewModule(safejs.Safe(js.Global().Get("WebAssembly").Get("Module").New(wasm)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return module
+}
+
+func TestNewModule(t *testing.T) {
+	newTestModule(t)
+	if _, err := NewModule(safejs.Safe(js.Global().Get("Object").New())); err == nil {
+		t.Error("expect NewModule to fail with a non WebAssembly.Module")
+	}
+}
+
+func TestCompileModule(t *testing.T) {
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := CompileModule(ctx, testWASMPath); !errors.Is(err, context.Canceled) {
+			t.Errorf("expect context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("fetch failure", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := CompileModule(ctx, "http://127.0.0.1:1/test.wasm")
+		var se *StartError
+		if !errors.As(err, &se) || se.Stage != StartStageFetch {
+			t.Errorf("expect a *StartError of the fetch stage, got %v", err)
+		}
+	})
+}
+
+func TestWasmWebWorkerConnModule(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{Path: testWASMPath, Module: newTestModule(t)}
+	w := startJSConn(t, workers, conn)
+	defer conn.Terminate()
+
+	want, err := buildWorkerConnJS(nil, nil, conn.Path, bootstrapOptions{SharedModule: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.script() != want {
+		t.Error("the worker is not started with the bootstrap script instantiating the shared module")
+	}
+
+	// The module is the first message sent to the worker.
+	select {
+	case event := <-w.events:
+		frame, ok := eventControlFrame(event)
+		if !ok || frame.Kind != frameModule {
+			t.Fatalf("expect the first message to be the %s frame", frameModule)
+		}
+		// The module is structured cloned to the worker.
+		if !safejs.Unsafe(frame.Payload).InstanceOf(js.Global().Get("WebAssembly").Get("Module")) {
+			t.Error("expect the payload to be a WebAssembly.Module")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the module")
+	}
+}
+
+func TestWasmWebWorkerConnStartContextCanceled(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{Path: testWASMPath, Module: newTestModule(t)}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- conn.StartContext(ctx)
+	}()
+	// The worker never sets up the connection.
+	w := workers.next()
+	cancel()
+	if err := waitTimeout(t, func() error { return <-errCh }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+	if !w.terminated() {
+		t.Error("expect the worker to be terminated")
+	}
+}
@@ -0,0 +1,257 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// NetAddr is the address of an endpoint of the net.Conn returned by NewNetConn.
+type NetAddr struct {
+	// Name is the name of the worker.
+	Name string
+
+	// Worker tells whether this is the worker side endpoint, otherwise, it is the controller side endpoint.
+	Worker bool
+}
+
+func (a NetAddr) Network() string {
+	return "wasmww"
+}
+
+func (a NetAddr) String() string {
+	if a.Worker {
+		return "worker/" + a.Name
+	}
+	return "controller/" + a.Name
+}
+
+// netConn implements the net.Conn by framing the written bytes into Uint8Array messages.
+type netConn struct {
+	conn   MessageConn
+	local  NetAddr
+	remote NetAddr
+
+	// readMu serializes the reads, and guards the fields below.
+	readMu  sync.Mutex
+	readBuf []byte
+	readEOF bool
+
+	// mu guards the fields below.
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// deadlineCh is closed and renewed whenever the read deadline is changed, to wake up the blocking Read.
+	deadlineCh  chan struct{}
+	writeClosed bool
+	closed      bool
+	closeCh     chan struct{}
+}
+
+var _ net.Conn = &netConn{}
+
+// NewNetConn returns a net.Conn that streams bytes over the connection, which is one of WasmWebWorkerConn,
+// WasmSharedWebWorkerConn, SelfConn and SelfSharedConnPort. The peer is expected to create a net.Conn over its connection as well.
+//
+// The local and remote addresses are NetAddr, derived from the worker name.
+//
+// The net.Conn consumes the event channel of the connection, hence it shall not be used together with other consumers of the channel.
+// Closing the net.Conn only signals EOF to the peer, but doesn't close the underlying connection.
+func NewNetConn(conn MessageConn) net.Conn {
+	var name string
+	var worker bool
+	switch conn := conn.(type) {
+	case *WasmWebWorkerConn:
+		name = conn.Name
+	case *WasmSharedWebWorkerConn:
+		name = conn.Name
+	case *SelfConn:
+		name, _ = conn.Name()
+		worker = true
+	case *SelfSharedConnPort:
+		name, _ = conn.conn.Name()
+		worker = true
+	}
+	return &netConn{
+		conn:       conn,
+		local:      NetAddr{Name: name, Worker: worker},
+		remote:     NetAddr{Name: name, Worker: !worker},
+		deadlineCh: make(chan struct{}),
+		closeCh:    make(chan struct{}),
+	}
+}
+
+func (c *netConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: c.local.Network(), Source: c.local, Addr: c.remote, Err: err}
+}
+
+// Read reads the data sent from the peer. It returns io.EOF once the peer closes (or half-closes) its net.Conn, or the underlying connection is closed.
+func (c *netConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.readBuf) == 0 {
+		if c.readEOF {
+			return 0, io.EOF
+		}
+
+		c.mu.Lock()
+		closed, deadline, deadlineCh := c.closed, c.readDeadline, c.deadlineCh
+		c.mu.Unlock()
+
+		if closed {
+			return 0, c.opError("read", net.ErrClosed)
+		}
+
+		if err := c.readEvent(deadline, deadlineCh); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// readEvent waits for the next event from the peer, and fills the read buffer (or marks EOF) accordingly.
+// It returns early without error when the read deadline is changed or the net.Conn is closed, so that the caller can re-check the state.
+func (c *netConn) readEvent(deadline time.Time, deadlineCh <-chan struct{}) error {
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return c.opError("read", os.ErrDeadlineExceeded)
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case event, ok := <-c.conn.EventChannel():
+		if !ok {
+			c.readEOF = true
+			return nil
+		}
+		data, err := event.Data()
+		if err != nil {
+			return c.opError("read", err)
+		}
+		if frame, ok := parseControlFrame(data); ok {
+			if frame.Kind == frameNetConnEOF {
+				c.readEOF = true
+			}
+			return nil
+		}
+		b, err := bytesFromJS(data)
+		if err != nil {
+			return c.opError("read", err)
+		}
+		c.readBuf = b
+	case <-timeoutCh:
+		return c.opError("read", os.ErrDeadlineExceeded)
+	case <-deadlineCh:
+	case <-c.closeCh:
+	}
+	return nil
+}
+
+// Write sends the data to the peer. It never blocks, as posting a message is asynchronous.
+func (c *netConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	closed, writeClosed, deadline := c.closed, c.writeClosed, c.writeDeadline
+	c.mu.Unlock()
+
+	if closed {
+		return 0, c.opError("write", net.ErrClosed)
+	}
+	if writeClosed {
+		return 0, c.opError("write", io.ErrClosedPipe)
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, c.opError("write", os.ErrDeadlineExceeded)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	msg, transfers, err := bytesToTransferable(p)
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+	if err := c.conn.PostMessage(msg, transfers); err != nil {
+		return 0, c.opError("write", err)
+	}
+	return len(p), nil
+}
+
+// CloseWrite shuts down the writing side of the net.Conn, after which the peer reads io.EOF.
+func (c *netConn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return c.opError("close", net.ErrClosed)
+	}
+	return c.closeWrite()
+}
+
+func (c *netConn) closeWrite() error {
+	if c.writeClosed {
+		return nil
+	}
+	c.writeClosed = true
+	return postControlFrame(c.conn, frameNetConnEOF, nil, nil)
+}
+
+// Close closes the net.Conn, which signals EOF to the peer if not yet, and unblocks any blocked Read.
+func (c *netConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return c.opError("close", net.ErrClosed)
+	}
+	err := c.closeWrite()
+	c.closed = true
+	close(c.closeCh)
+	return err
+}
+
+func (c *netConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *netConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *netConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *netConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return c.opError("set", net.ErrClosed)
+	}
+	c.readDeadline = t
+	close(c.deadlineCh)
+	c.deadlineCh = make(chan struct{})
+	return nil
+}
+
+func (c *netConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return c.opError("set", net.ErrClosed)
+	}
+	c.writeDeadline = t
+	return nil
+}
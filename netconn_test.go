@@ -0,0 +1,185 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// postBytes posts the bytes to the controller, as the net.Conn of the worker writes.
+func (w *jsWorker) postBytes(b string) {
+	w.t.Helper()
+	msg, transfers, err := bytesToTransferable([]byte(b))
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	if err := w.PostMessage(msg, transfers); err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// readTimeout calls the Read of the c, and fails the test if it doesn't return in time.
+func readTimeout(t *testing.T, c net.Conn, p []byte) (int, error) {
+	t.Helper()
+	var n int
+	err := waitTimeout(t, func() error {
+		var err error
+		n, err = c.Read(p)
+		return err
+	})
+	return n, err
+}
+
+func TestNetConn(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{Name: "w1"}
+	w := startJSConn(t, workers, conn)
+	c := NewNetConn(conn)
+
+	if got, want := c.LocalAddr().String(), "controller/w1"; got != want {
+		t.Errorf("got local address %q, want %q", got, want)
+	}
+	if got, want := c.RemoteAddr().String(), "worker/w1"; got != want {
+		t.Errorf("got remote address %q, want %q", got, want)
+	}
+
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := bytesFromJS(w.nextMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Errorf("the worker got %q, want %q", b, "ping")
+	}
+
+	// The messages are read as a stream, regardless of the buffer size.
+	w.postBytes("po")
+	w.postBytes("ng!")
+	var got []byte
+	buf := make([]byte, 2)
+	for len(got) < 5 {
+		n, err := readTimeout(t, c, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "pong!" {
+		t.Errorf("got %q, want %q", got, "pong!")
+	}
+
+	// The half-close of the worker is read as EOF, while the controller can still write.
+	w.postFrame(frameNetConnEOF, nil)
+	if _, err := readTimeout(t, c, buf); !errors.Is(err, io.EOF) {
+		t.Errorf("expect io.EOF after the worker half-closes, got %v", err)
+	}
+	if _, err := c.Write([]byte("more")); err != nil {
+		t.Errorf("expect writing after the worker half-closes to succeed, got %v", err)
+	}
+
+	if err := c.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	w.nextFrame(frameNetConnEOF)
+	if _, err := c.Write([]byte("closed")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expect io.ErrClosedPipe writing after CloseWrite, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expect net.ErrClosed closing twice, got %v", err)
+	}
+}
+
+func TestNetConnDeadline(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{}
+	startJSConn(t, workers, conn)
+	c := NewNetConn(conn)
+	buf := make([]byte, 8)
+
+	if err := c.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readTimeout(t, c, buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect os.ErrDeadlineExceeded, got %v", err)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expect a timeout net.Error, got %v", err)
+	}
+
+	// Changing the deadline wakes up the blocking Read.
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Read(buf)
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := c.SetReadDeadline(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitTimeout(t, func() error { return <-errCh }); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expect os.ErrDeadlineExceeded after moving the deadline, got %v", err)
+	}
+
+	if err := c.SetWriteDeadline(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("late")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expect os.ErrDeadlineExceeded writing after the deadline, got %v", err)
+	}
+
+	// Closing unblocks the blocking Read.
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, err := c.Read(buf)
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitTimeout(t, func() error { return <-errCh }); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expect net.ErrClosed reading after Close, got %v", err)
+	}
+	if err := c.SetDeadline(time.Now()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expect net.ErrClosed setting the deadline after Close, got %v", err)
+	}
+}
+
+func TestNetConnWorkerExit(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{}
+	w := startJSConn(t, workers, conn)
+	c := NewNetConn(conn)
+
+	w.postBytes("last")
+	w.postFrame(frameExit, 0)
+	var got []byte
+	if err := waitTimeout(t, func() error {
+		var err error
+		got, err = io.ReadAll(c)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "last" {
+		t.Errorf("got %q, want %q", got, "last")
+	}
+}
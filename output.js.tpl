@@ -0,0 +1,30 @@
+// Flush the output that is buffered by the Go program but not yet flushed when it exits, via the post function if it is redirected to the controller,
+// otherwise, to the console. The consecutive chunks of the same file descriptor are merged into one.
+function flushPendingOutput(post) {
+    const pending = self["{{.PendingOutputVar}}"];
+    if (!pending || pending.discard) {
+        return;
+    }
+    const chunks = pending.chunks;
+    pending.chunks = [];
+    let i = 0;
+    while (i < chunks.length) {
+        const fd = chunks[i].fd;
+        let j = i;
+        let size = 0;
+        for (; j < chunks.length && chunks[j].fd === fd; j++) {
+            size += chunks[j].data.length;
+        }
+        const data = new Uint8Array(size);
+        let offset = 0;
+        for (; i < j; i++) {
+            data.set(chunks[i].data, offset);
+            offset += chunks[i].data.length;
+        }
+        if (pending.controller) {
+            post(controlFrame(fd === 2 ? "{{.StderrKind}}" : "{{.StdoutKind}}", data), [data.buffer]);
+        } else {
+            console.log(new TextDecoder().decode(data));
+        }
+    }
+}
@@ -0,0 +1,166 @@
+//go:build js && wasm
+
+package wasmww
+
+import "strconv"
+
+type exitKind int
+
+const (
+	// exitKindExited means the Go program exited, by returning from main, calling os.Exit or panicing.
+	exitKindExited exitKind = iota
+	// exitKindClosed means the worker closed itself, e.g. via SelfConn.Close(), or as told by WasmSharedWebWorkerMgmtConn.Close().
+	exitKindClosed
+	// exitKindTerminated means the worker is terminated by the controller.
+	exitKindTerminated
+	// exitKindCrashed means the WASM crashed without exiting, e.g. due to a WebAssembly trap.
+	exitKindCrashed
+)
+
+// ProcessState stores information about the exit of a worker, as reported by Wait.
+type ProcessState struct {
+	kind     exitKind
+	exitCode int
+
+	// closeCode and reason are the code and reason passed to CloseWithReason when the worker closed itself,
+	// or the error message of the crash.
+	closeCode int
+	reason    string
+}
+
+// ExitCode returns the exit code of the exited Go program in the worker.
+// A panic in the Go program is reported as exit code 2, same as a native Go program.
+// If the worker closes itself, it returns 0. If the worker is terminated by the controller, or crashed, it returns -1.
+func (p *ProcessState) ExitCode() int {
+	if p == nil {
+		return -1
+	}
+	return p.exitCode
+}
+
+// Exited reports whether the Go program in the worker exited,
+// either by returning from main, calling os.Exit, or panicing.
+func (p *ProcessState) Exited() bool {
+	if p == nil {
+		return false
+	}
+	return p.kind == exitKindExited
+}
+
+// Closed reports whether the worker closed itself, e.g. via SelfConn.Close(), or as told by WasmSharedWebWorkerMgmtConn.Close().
+func (p *ProcessState) Closed() bool {
+	if p == nil {
+		return false
+	}
+	return p.kind == exitKindClosed
+}
+
+// Terminated reports whether the worker is terminated by the controller.
+func (p *ProcessState) Terminated() bool {
+	if p == nil {
+		return false
+	}
+	return p.kind == exitKindTerminated
+}
+
+// Crashed reports whether the WASM in the worker crashed without exiting, e.g. due to a WebAssembly trap.
+func (p *ProcessState) Crashed() bool {
+	if p == nil {
+		return false
+	}
+	return p.kind == exitKindCrashed
+}
+
+// Success reports whether the worker exited successfully, i.e. exited with status 0, or closed itself.
+func (p *ProcessState) Success() bool {
+	if p == nil {
+		return false
+	}
+	return (p.kind == exitKindExited || p.kind == exitKindClosed) && p.exitCode == 0
+}
+
+// CloseReason returns the reason of the exit of the worker.
+func (p *ProcessState) CloseReason() *CloseReason {
+	if p == nil {
+		return nil
+	}
+	switch p.kind {
+	case exitKindClosed:
+		return &CloseReason{Kind: CloseWorkerClosed, Code: p.closeCode, Reason: p.reason}
+	case exitKindTerminated:
+		return &CloseReason{Kind: CloseTerminated}
+	case exitKindCrashed:
+		return &CloseReason{Kind: CloseCrashed, Reason: p.reason}
+	default:
+		return &CloseReason{Kind: CloseExited, Code: p.exitCode}
+	}
+}
+
+func (p *ProcessState) String() string {
+	if p == nil {
+		return "<nil>"
+	}
+	switch p.kind {
+	case exitKindClosed:
+		return "closed"
+	case exitKindTerminated:
+		return "terminated"
+	case exitKindCrashed:
+		return "crashed: " + p.reason
+	default:
+		return "exit status " + strconv.Itoa(p.exitCode)
+	}
+}
+
+// ExitError is returned by Wait when the worker doesn't exit successfully.
+type ExitError struct {
+	*ProcessState
+
+	// Stderr holds the standard error output from the WasmWebWorkerConn.Output method
+	// if WasmWebWorkerConn.Stderr was not otherwise being collected.
+	Stderr []byte
+}
+
+func (e *ExitError) Error() string {
+	return e.ProcessState.String()
+}
+
+func newExitedState(code int) *ProcessState {
+	return &ProcessState{kind: exitKindExited, exitCode: code}
+}
+
+func newClosedState(msg closeMessage) *ProcessState {
+	return &ProcessState{kind: exitKindClosed, closeCode: msg.Code, reason: msg.Reason}
+}
+
+func newTerminatedState() *ProcessState {
+	return &ProcessState{kind: exitKindTerminated, exitCode: -1}
+}
+
+func newCrashedState(message string) *ProcessState {
+	return &ProcessState{kind: exitKindCrashed, exitCode: -1, reason: message}
+}
+
+// parseExitFrame parses the exit frame sent by the worker bootstrap script, whose payload is the exit code,
+// or the crash frame, whose payload is the error message.
+func parseExitFrame(frame controlFrame) (*ProcessState, bool) {
+	if message, ok := parseCrashFrame(frame); ok {
+		return newCrashedState(message), true
+	}
+	if frame.Kind != frameExit {
+		return nil, false
+	}
+	code, err := frame.Payload.Int()
+	if err != nil {
+		return nil, false
+	}
+	return newExitedState(code), true
+}
+
+// waitError returns the error to be returned by Wait for the given state.
+func waitError(state *ProcessState) error {
+	if state == nil || state.Success() {
+		return nil
+	}
+	return &ExitError{ProcessState: state}
+}
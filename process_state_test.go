@@ -0,0 +1,101 @@
+//go:build js && wasm
+
+package wasmww
+
+import "testing"
+
+func TestProcessState(t *testing.T) {
+	cases := []struct {
+		name       string
+		state      *ProcessState
+		exited     bool
+		closed     bool
+		terminated bool
+		crashed    bool
+		success    bool
+		exitCode   int
+		str        string
+		reason     *CloseReason
+	}{
+		{
+			name:     "nil",
+			exitCode: -1,
+			str:      "<nil>",
+		},
+		{
+			name:     "exit 0",
+			state:    newExitedState(0),
+			exited:   true,
+			success:  true,
+			exitCode: 0,
+			str:      "exit status 0",
+			reason:   &CloseReason{Kind: CloseExited},
+		},
+		{
+			name:     "exit 2",
+			state:    newExitedState(2),
+			exited:   true,
+			exitCode: 2,
+			str:      "exit status 2",
+			reason:   &CloseReason{Kind: CloseExited, Code: 2},
+		},
+		{
+			name:     "closed",
+			state:    newClosedState(closeMessage{Code: 3, Reason: "bye"}),
+			closed:   true,
+			success:  true,
+			exitCode: 0,
+			str:      "closed",
+			reason:   &CloseReason{Kind: CloseWorkerClosed, Code: 3, Reason: "bye"},
+		},
+		{
+			name:       "terminated",
+			state:      newTerminatedState(),
+			terminated: true,
+			exitCode:   -1,
+			str:        "terminated",
+			reason:     &CloseReason{Kind: CloseTerminated},
+		},
+		{
+			name:     "crashed",
+			state:    newCrashedState("unreachable"),
+			crashed:  true,
+			exitCode: -1,
+			str:      "crashed: unreachable",
+			reason:   &CloseReason{Kind: CloseCrashed, Reason: "unreachable"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := c.state
+			if got := s.Exited(); got != c.exited {
+				t.Errorf("Exited: got %t, want %t", got, c.exited)
+			}
+			if got := s.Closed(); got != c.closed {
+				t.Errorf("Closed: got %t, want %t", got, c.closed)
+			}
+			if got := s.Terminated(); got != c.terminated {
+				t.Errorf("Terminated: got %t, want %t", got, c.terminated)
+			}
+			if got := s.Crashed(); got != c.crashed {
+				t.Errorf("Crashed: got %t, want %t", got, c.crashed)
+			}
+			if got := s.Success(); got != c.success {
+				t.Errorf("Success: got %t, want %t", got, c.success)
+			}
+			if got := s.ExitCode(); got != c.exitCode {
+				t.Errorf("ExitCode: got %d, want %d", got, c.exitCode)
+			}
+			if got := s.String(); got != c.str {
+				t.Errorf("String: got %q, want %q", got, c.str)
+			}
+			if got := s.CloseReason(); (got == nil) != (c.reason == nil) || (got != nil && *got != *c.reason) {
+				t.Errorf("CloseReason: got %v, want %v", got, c.reason)
+			}
+			// Wait returns nil for a nil state, i.e. the worker isn't started.
+			if err, wantErr := waitError(s), s != nil && !c.success; (err != nil) != wantErr {
+				t.Errorf("waitError: got %v, want error %t", err, wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+//go:build js && wasm
+
+package wasmww
+
+import "sync"
+
+// relayError records the errors encountered by the relay goroutine of a connection, e.g. failing to write to the Stdout,
+// which are reported to the handler, if any, instead of crashing the controller.
+type relayError struct {
+	handler func(error)
+
+	mu    sync.Mutex
+	first error
+}
+
+func newRelayError(handler func(error)) *relayError {
+	return &relayError{handler: handler}
+}
+
+func (e *relayError) report(err error) {
+	e.mu.Lock()
+	if e.first == nil {
+		e.first = err
+	}
+	e.mu.Unlock()
+	if e.handler != nil {
+		e.handler(err)
+	}
+}
+
+// err returns the first reported error, if any.
+func (e *relayError) err() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.first
+}
@@ -0,0 +1,222 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrRPCConnClosed is returned by Call when the connection is closed before the reply is received.
+var ErrRPCConnClosed = errors.New("wasmww: connection closed")
+
+// RPCError is returned by Call when the handler in the worker returns an error, or there is no handler registered for the method.
+type RPCError struct {
+	Method  string
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("wasmww: rpc %s: %s", e.Method, e.Message)
+}
+
+// RPCHandlerFunc handles the RPC call of a method, which is registered in the worker via the Handle method of SelfConn or SelfSharedConnPort.
+// The params is the JSON encoded args of the call, and the returned reply is JSON encoded back to the caller.
+// The ctx is canceled once the caller's ctx is done, or the connection is closed.
+type RPCHandlerFunc func(ctx context.Context, params json.RawMessage) (reply any, err error)
+
+type rpcRequest struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type rpcCancel struct {
+	ID uint64 `json:"id"`
+}
+
+// rpcClient tracks the in-flight calls on the controller side, and correlates the responses to them by the request ID.
+type rpcClient struct {
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan rpcResponse
+	closed  bool
+}
+
+func newRPCClient() *rpcClient {
+	return &rpcClient{pending: map[uint64]chan rpcResponse{}}
+}
+
+func (c *rpcClient) call(ctx context.Context, poster MessagePoster, method string, args any, reply any) error {
+	params, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("encoding args: %w", err)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrRPCConnClosed
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan rpcResponse, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := postJSONFrame(poster, frameRPCRequest, rpcRequest{ID: id, Method: method, Params: params}); err != nil {
+		c.remove(id)
+		return err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return ErrRPCConnClosed
+		}
+		if resp.Error != "" {
+			return &RPCError{Method: method, Message: resp.Error}
+		}
+		if reply != nil && len(resp.Result) != 0 {
+			if err := json.Unmarshal(resp.Result, reply); err != nil {
+				return fmt.Errorf("decoding reply: %w", err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		if c.remove(id) {
+			// Notify the worker to cancel the handling, on a best-effort basis.
+			postJSONFrame(poster, frameRPCCancel, rpcCancel{ID: id})
+		}
+		return ctx.Err()
+	}
+}
+
+// remove removes the pending call, it returns false if the call is not pending any more.
+func (c *rpcClient) remove(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	return ok
+}
+
+// dispatch delivers the response frame to the pending call.
+func (c *rpcClient) dispatch(frame controlFrame) {
+	var resp rpcResponse
+	if err := frame.unmarshal(&resp); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.pending[resp.ID]; ok {
+		ch <- resp
+		delete(c.pending, resp.ID)
+	}
+}
+
+// close fails all the in-flight calls, and any further call.
+func (c *rpcClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// rpcServer serves the RPC requests on the worker side with the registered handlers.
+type rpcServer struct {
+	mu       sync.Mutex
+	handlers map[string]RPCHandlerFunc
+	inflight map[uint64]context.CancelFunc
+}
+
+func (s *rpcServer) handle(method string, handler RPCHandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = map[string]RPCHandlerFunc{}
+	}
+	s.handlers[method] = handler
+}
+
+// dispatch serves the request frame or the cancel frame.
+func (s *rpcServer) dispatch(poster MessagePoster, frame controlFrame) {
+	switch frame.Kind {
+	case frameRPCRequest:
+		var req rpcRequest
+		if err := frame.unmarshal(&req); err != nil {
+			return
+		}
+		s.serve(poster, req)
+	case frameRPCCancel:
+		var cancel rpcCancel
+		if err := frame.unmarshal(&cancel); err != nil {
+			return
+		}
+		s.mu.Lock()
+		if cancelFunc, ok := s.inflight[cancel.ID]; ok {
+			cancelFunc()
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *rpcServer) serve(poster MessagePoster, req rpcRequest) {
+	s.mu.Lock()
+	handler, ok := s.handlers[req.Method]
+	if !ok {
+		s.mu.Unlock()
+		postJSONFrame(poster, frameRPCResponse, rpcResponse{ID: req.ID, Error: "method not found"})
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.inflight == nil {
+		s.inflight = map[uint64]context.CancelFunc{}
+	}
+	s.inflight[req.ID] = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.inflight, req.ID)
+			s.mu.Unlock()
+			cancel()
+		}()
+
+		resp := rpcResponse{ID: req.ID}
+		reply, err := handler(ctx, req.Params)
+		if err == nil {
+			resp.Result, err = json.Marshal(reply)
+			if err != nil {
+				err = fmt.Errorf("encoding reply: %w", err)
+			}
+		}
+		if err != nil {
+			resp.Result = nil
+			resp.Error = err.Error()
+		}
+		postJSONFrame(poster, frameRPCResponse, resp)
+	}()
+}
+
+// close cancels all the in-flight handlings.
+func (s *rpcServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.inflight {
+		cancel()
+	}
+}
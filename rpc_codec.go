@@ -0,0 +1,115 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+)
+
+// rpcCodec sends each net/rpc message, i.e. the header followed by the body, as a gob stream in one Uint8Array message.
+type rpcCodec struct {
+	conn MessageConn
+	dec  *gob.Decoder
+}
+
+func (c *rpcCodec) write(header, body any) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	if err := enc.Encode(body); err != nil {
+		return err
+	}
+	msg, transfers, err := bytesToTransferable(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return c.conn.PostMessage(msg, transfers)
+}
+
+// readHeader receives the next message, and decodes its header. It returns io.EOF once the connection is closed.
+func (c *rpcCodec) readHeader(header any) error {
+	event, ok := <-c.conn.EventChannel()
+	if !ok {
+		return io.EOF
+	}
+	data, err := event.Data()
+	if err != nil {
+		return err
+	}
+	b, err := bytesFromJS(data)
+	if err != nil {
+		return err
+	}
+	c.dec = gob.NewDecoder(bytes.NewReader(b))
+	return c.dec.Decode(header)
+}
+
+// readBody decodes the body of the current message. A nil body is discarded.
+func (c *rpcCodec) readBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+// Close closes the connection, if it supports to be closed.
+func (c *rpcCodec) Close() error {
+	if closer, ok := c.conn.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type rpcClientCodec struct {
+	rpcCodec
+}
+
+var _ rpc.ClientCodec = &rpcClientCodec{}
+
+// NewRPCClientCodec returns a net/rpc ClientCodec backed by the connection, typically a WasmWebWorkerConn or a WasmSharedWebWorkerConn,
+// which is to be used with rpc.NewClientWithCodec.
+//
+// The codec consumes the event channel of the connection, hence it shall not be used together with other consumers of the channel.
+func NewRPCClientCodec(conn MessageConn) rpc.ClientCodec {
+	return &rpcClientCodec{rpcCodec{conn: conn}}
+}
+
+func (c *rpcClientCodec) WriteRequest(r *rpc.Request, body any) error {
+	return c.write(r, body)
+}
+
+func (c *rpcClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.readHeader(r)
+}
+
+func (c *rpcClientCodec) ReadResponseBody(body any) error {
+	return c.readBody(body)
+}
+
+type rpcServerCodec struct {
+	rpcCodec
+}
+
+var _ rpc.ServerCodec = &rpcServerCodec{}
+
+// NewRPCServerCodec returns a net/rpc ServerCodec backed by the connection, typically a SelfConn or a SelfSharedConnPort,
+// which is to be used with rpc.ServeCodec.
+//
+// The codec consumes the event channel of the connection, hence it shall not be used together with other consumers of the channel.
+func NewRPCServerCodec(conn MessageConn) rpc.ServerCodec {
+	return &rpcServerCodec{rpcCodec{conn: conn}}
+}
+
+func (c *rpcServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.readHeader(r)
+}
+
+func (c *rpcServerCodec) ReadRequestBody(body any) error {
+	return c.readBody(body)
+}
+
+func (c *rpcServerCodec) WriteResponse(r *rpc.Response, body any) error {
+	return c.write(r, body)
+}
@@ -0,0 +1,79 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"runtime"
+	"syscall/js"
+)
+
+// Runtime is the toolchain that the WASM of the worker is built with, which determines the bootstrap script of the worker.
+type Runtime int
+
+const (
+	// RuntimeGo is for the WASM built by the standard Go toolchain (GOOS=js GOARCH=wasm), whose glue script is the wasm_exec.js of Go.
+	// This is the default.
+	RuntimeGo Runtime = iota
+
+	// RuntimeTinyGo is for the WASM built by TinyGo (-target=wasm), whose glue script is the wasm_exec.js of TinyGo, see GlueURL.
+	// The Args and Env are passed via the WASI args_get and environ_get imports, and the output is captured from the WASI fd_write import.
+	//
+	// Stdin is not supported, reading from the os.Stdin in the worker always returns an error. Setting the Stdin of the WasmWebWorkerConn fails the Start.
+	// A panic of the Go program crashes the WASM (see CloseCrashed), instead of exiting with status 2.
+	RuntimeTinyGo
+
+	// RuntimeWASI is for a WASI (preview 1) module that is not a Go program, e.g. built from Rust, C or Zig, which is only supported by WasmWebWorkerConn.
+	// The Args and Env are passed as the WASI args and environ, the output written to the fd 1 and 2 is sent to the Stdout and Stderr,
+	// and the exit code passed to proc_exit is reported as the exit status. Returning from _start is the same as exiting with status 0.
+	//
+	// There is no file system, and as the module runs synchronously, the stdin is not supported: reading from the fd 0 always hits EOF,
+	// and setting the Stdin of the WasmWebWorkerConn fails the Start.
+	// The output redirection (e.g. SetWriteToConsole), and the features relying on the SelfConn, e.g. Call and Transport, are not supported.
+	// The GlueURL and GlueJS are ignored.
+	RuntimeWASI
+)
+
+// supportsStdin tells whether the worker of the runtime consumes the stdin sent from the controller.
+func (r Runtime) supportsStdin() bool {
+	return r == RuntimeGo
+}
+
+func (r Runtime) String() string {
+	switch r {
+	case RuntimeGo:
+		return "go"
+	case RuntimeTinyGo:
+		return "tinygo"
+	case RuntimeWASI:
+		return "wasi"
+	default:
+		return "unknown"
+	}
+}
+
+// writeSyncVar is the JS global variable defined by the TinyGo bootstrap script, which is the function called with the output written to the fd 1 and 2.
+// If it is null, the output is written to the console by the glue script of TinyGo.
+const writeSyncVar = "__wasmww_write_sync__"
+
+// isTinyGo tells whether this Go program is built by TinyGo, in which case, the fs of the glue script is not used for the stdin/stdout/stderr.
+func isTinyGo() bool {
+	return runtime.Compiler == "tinygo"
+}
+
+// getWriteSyncFunc returns the "writeSync" implementation called by Go, which is either the fs.writeSync of the Go glue script,
+// or the function called by the fd_write of the TinyGo bootstrap script.
+func getWriteSyncFunc() js.Value {
+	if isTinyGo() {
+		return js.Global().Get(writeSyncVar)
+	}
+	return js.Global().Get("fs").Get("writeSync")
+}
+
+// setWriteSyncFunc sets the "writeSync" implementation called by Go, see getWriteSyncFunc.
+func setWriteSyncFunc(fn js.Value) {
+	if isTinyGo() {
+		js.Global().Set(writeSyncVar, fn)
+		return
+	}
+	js.Global().Get("fs").Set("writeSync", fn)
+}
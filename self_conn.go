@@ -4,6 +4,8 @@ package wasmww
 
 import (
 	"context"
+	"log/slog"
+	"net/http"
 	"syscall/js"
 
 	"github.com/hack-pad/safejs"
@@ -14,8 +16,26 @@ import (
 type WebWorkerCloseFunc func() error
 
 type SelfConn struct {
-	self      *worker.GlobalSelf
-	closeFunc WebWorkerCloseFunc
+	// Codec is used to encode and decode the values for Send and Receive.
+	// If Codec is nil, JSONCodec is used.
+	Codec Codec
+
+	// WriteSyncOptions configures the buffering of the stdout/stderr redirected to the controller by SetupConn.
+	WriteSyncOptions WriteSyncOptions
+
+	// CaptureConsole makes SetupConn capture the console output of this worker, e.g. from the Go glue file, syscall/js calls or any other JS code,
+	// and forward it to the peering WasmWebWorkerConn, which writes the "warn" and "error" levels to its Stderr, and the others to its Stdout.
+	// The captured messages follow the output redirection: they are written to the console via the method of the same level in the console mode
+	// (e.g. after ResetWriteSync), to both in the tee mode, and dropped in the discard mode.
+	// Note that the Go output written to the console in the tee mode is not captured, as it is sent to the controller already.
+	CaptureConsole bool
+
+	self          *worker.GlobalSelf
+	writeSyncOpts WriteSyncOptions
+	rpc           rpcServer
+	http          httpServer
+	closeFunc     func(msg closeMessage) error
+	eventCh       <-chan types.MessageEventMessage
 
 	// originWriteSync stores the original js.Func of the "writeSync" from the Go glue file.
 	//
@@ -36,7 +56,7 @@ func NewSelfConn() (*SelfConn, error) {
 	}
 	return &SelfConn{
 		self:            self,
-		originWriteSync: js.Global().Get("fs").Get("writeSync"),
+		originWriteSync: getWriteSyncFunc(),
 	}, nil
 }
 
@@ -56,24 +76,69 @@ func (s *SelfConn) SetupConn() (_ <-chan types.MessageEventMessage, err error) {
 		return nil, err
 	}
 
-	s.closeFunc = func() error {
+	// Redirect the reading of stdin to the data sent from the controller.
+	stdin := newBufferedPipe()
+	setReadStdin(stdin)
+
+	// Create a channel to relay the event from the onmessage channel to the consuming channel,
+	// except the control frames, e.g. the stdin frames, which are written to the stdin pipe, and the RPC and HTTP frames, which are served by the registered handlers.
+	// The events are queued, so that the control frames are handled even if the consuming channel isn't drained.
+	queue := newEventQueue()
+	eventCh := queue.ch
+	go func() {
+		for event := range ch {
+			frame, ok := eventControlFrame(event)
+			if !ok {
+				queue.push(event)
+				continue
+			}
+			switch frame.Kind {
+			case frameStdin:
+				if b, err := bytesFromJS(frame.Payload); err == nil {
+					stdin.write(b)
+				}
+			case frameStdinEOF:
+				stdin.closeWrite(nil)
+			case frameWriteToConsole, frameWriteToController, frameWriteToDiscard, frameWriteToTee:
+				redirectOutput(frame.Kind, s.NewMsgWriterToControllerStdout(), s.NewMsgWriterToControllerStderr(), s.writeSyncOpts, s.originWriteSync)
+			case frameRPCRequest, frameRPCCancel:
+				s.rpc.dispatch(s.self, frame)
+			case frameHTTP:
+				s.http.dispatch(s.self, frame)
+			case frameNetConnEOF:
+				queue.push(event)
+			}
+		}
+		s.rpc.close()
+		s.http.close()
+		queue.close()
+	}()
+
+	s.closeFunc = func(msg closeMessage) error {
 		cancel()
-		for range ch {
+		for range eventCh {
 		}
-		if err := s.self.PostMessage(safejs.Safe(js.ValueOf(CLOSE_EVENT)), nil); err != nil {
+		// Flush the buffered output before the close frame, which stops the controller from receiving the output.
+		FlushWriteSync()
+		if err := postCloseFrame(s.self, msg); err != nil {
 			return err
 		}
 		return s.self.Close()
 	}
 
 	//Redirect stdout/stderr to the controller, instead of printing to the JS console.
-	SetWriteSync(
+	s.writeSyncOpts = s.WriteSyncOptions
+	if js.Global().Get(orderedOutputVar).Truthy() {
+		s.writeSyncOpts.Ordered = true
+	}
+	SetWriteSyncWithOptions(
 		[]MsgWriter{
 			s.NewMsgWriterToControllerStdout(),
 		},
 		[]MsgWriter{
 			s.NewMsgWriterToControllerStderr(),
 		},
+		s.writeSyncOpts,
 	)
 
 	// Notify the controller that this worker has started listening
@@ -82,7 +147,15 @@ func (s *SelfConn) SetupConn() (_ <-chan types.MessageEventMessage, err error) {
 		return nil, err
 	}
 
-	return ch, nil
+	if s.CaptureConsole {
+		if err := captureConsole(s.self); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	s.eventCh = eventCh
+	return eventCh, nil
 }
 
 func (s *SelfConn) Name() (string, error) {
@@ -93,19 +166,69 @@ func (s *SelfConn) PostMessage(message safejs.Value, transfers []safejs.Value) e
 	return s.self.PostMessage(message, transfers)
 }
 
+// EventChannel returns the channel that receives events sent from the peering WasmWebWorkerConn,
+// which is the same channel returned by SetupConn().
+func (s *SelfConn) EventChannel() <-chan types.MessageEventMessage {
+	return s.eventCh
+}
+
+// Handle registers the handler for the RPC method, which is called by the peering WasmWebWorkerConn.Call.
+// The handlers can be registered either before or after SetupConn.
+func (s *SelfConn) Handle(method string, handler RPCHandlerFunc) {
+	s.rpc.handle(method, handler)
+}
+
+// ServeHTTP serves the HTTP requests sent via the peering WasmWebWorkerConn.Transport with the handler.
+// It returns immediately, each request is served in its own goroutine. The handler can be set either before or after SetupConn.
+func (s *SelfConn) ServeHTTP(handler http.Handler) {
+	s.http.setHandler(handler)
+}
+
+// Send encodes v with the Codec, and sends it to the controller.
+func (s *SelfConn) Send(v any) error {
+	return send(s.self, s.Codec, v)
+}
+
+// Receive receives the next event sent from the controller, and decodes it with the Codec into v.
+// It returns io.EOF once the connection is closed.
+//
+// Receive consumes the event channel returned by SetupConn(), hence it shall not be used together with other consumers of the channel.
+func (s *SelfConn) Receive(v any) error {
+	return receive(s.eventCh, s.Codec, v)
+}
+
+// ResetWriteSync flushes the buffered output, and restores the "writeSync" of the Go glue file, which writes to the console.
 func (s *SelfConn) ResetWriteSync() {
-	js.Global().Get("fs").Set("writeSync", s.originWriteSync)
+	resetWriteSync(s.originWriteSync)
+}
+
+// LogHandler returns a slog.Handler that sends the log records to the peering WasmWebWorkerConn, which handles them with its LogHandler.
+// The records carry the level, time, message, attributes and the worker name. It can only be used after SetupConn.
+// The opts is optional, only its Level and ReplaceAttr are used.
+func (s *SelfConn) LogHandler(opts *slog.HandlerOptions) slog.Handler {
+	name, _ := s.Name()
+	return newLogHandler(func() MessagePoster {
+		if s.eventCh == nil {
+			return nil
+		}
+		return s.self
+	}, name, opts)
 }
 
 func (s *SelfConn) NewMsgWriterToControllerStdout() MsgWriter {
-	return &msgWriterController{poster: s.self, prefix: STDOUT_EVENT}
+	return &msgWriterController{poster: s.self, kind: frameStdout}
 }
 
 func (s *SelfConn) NewMsgWriterToControllerStderr() MsgWriter {
-	return &msgWriterController{poster: s.self, prefix: STDERR_EVENT}
+	return &msgWriterController{poster: s.self, kind: frameStderr}
 }
 
 // Close closes the web worker, and close the event channel on the controller side.
 func (s *SelfConn) Close() error {
-	return s.closeFunc()
+	return s.closeFunc(closeMessage{})
+}
+
+// CloseWithReason is like Close, but attaches the code and reason to the close, which is reported by WasmWebWorkerConn.CloseReason.
+func (s *SelfConn) CloseWithReason(code int, reason string) error {
+	return s.closeFunc(closeMessage{Code: code, Reason: reason})
 }
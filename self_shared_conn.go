@@ -5,6 +5,8 @@ package wasmww
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"slices"
 	"sync"
 	"syscall/js"
 
@@ -14,10 +16,22 @@ import (
 )
 
 type SelfSharedConn struct {
+	// WriteSyncOptions configures the buffering of the stdout/stderr redirected to the controller by SetupConn.
+	WriteSyncOptions WriteSyncOptions
+
+	// CaptureConsole makes SetupConn capture the console output of this worker, e.g. from the Go glue file, syscall/js calls or any other JS code,
+	// and forward it via the mgmt port, which is read from the Stderr() of the WasmSharedWebWorkerMgmtConn for the "warn" and "error" levels,
+	// and from the Stdout() for the others.
+	// The captured messages follow the output redirection: they are written to the console via the method of the same level in the console mode
+	// (e.g. after ResetWriteSync), to both in the tee mode, and dropped in the discard mode.
+	// Note that the Go output written to the console in the tee mode is not captured, as it is sent to the controller already.
+	CaptureConsole bool
+
 	self      *sharedworker.GlobalSelf
-	closeFunc WebWorkerCloseFunc
+	closeFunc func(msg closeMessage) error
 
-	ports []*SelfSharedConnPort
+	portsMu sync.Mutex
+	ports   []*SelfSharedConnPort
 
 	// mgmtPort is a special port that only used for sending stdout/stderr to the peer, and only receive the mgmt message from there.
 	// It is guaranteed to be set on the first message port setup.
@@ -34,7 +48,7 @@ func NewSelfSharedConn() (*SelfSharedConn, error) {
 	}
 	return &SelfSharedConn{
 		self:            self,
-		originWriteSync: js.Global().Get("fs").Get("writeSync"),
+		originWriteSync: getWriteSyncFunc(),
 	}, nil
 }
 
@@ -80,13 +94,14 @@ func (s *SelfSharedConn) SetupConn() (_ <-chan *SelfSharedConnPort, err error) {
 	s.mgmtPort = mgmtPort
 
 	//Redirect the stdout/stderr to this port
-	SetWriteSync(
+	SetWriteSyncWithOptions(
 		[]MsgWriter{
 			s.NewMsgWriterToControllerStdout(),
 		},
 		[]MsgWriter{
 			s.NewMsgWriterToControllerStderr(),
 		},
+		s.WriteSyncOptions,
 	)
 
 	// Listening on mgmt message from this port, currently, only close event will be sent through it.
@@ -100,36 +115,28 @@ func (s *SelfSharedConn) SetupConn() (_ <-chan *SelfSharedConnPort, err error) {
 	go func() {
 		defer wg1.Done()
 		for event := range mgmtCh {
-			data, err := event.Data()
-			if err != nil {
+			frame, ok := eventControlFrame(event)
+			if !ok {
 				continue
 			}
-			str, err := data.String()
-			if err != nil {
-				continue
-			}
-			switch str {
-			case CLOSE_EVENT:
-				ports := make([]*SelfSharedConnPort, len(s.ports))
-				copy(ports, s.ports)
-				for _, port := range ports {
-					port.closeFunc()
+			switch frame.Kind {
+			case frameClose:
+				for _, port := range s.connectedPorts() {
+					port.closeFunc(closeMessage{Worker: true})
 				}
 
+				// Flush the buffered output before replying the close frame, which the controller waits for before it stops relaying the output.
+				FlushWriteSync()
+				postCloseFrame(mgmtPort, closeMessage{})
+
 				cancel()
 				wg2.Wait()
 
 				// Close this web worker
 				s.self.Close()
 
-			case WRITE_TO_CONSOLE_EVENT:
-				s.ResetWriteSync()
-
-			case WRITE_TO_CONTROLLER_EVENT:
-				SetWriteSync(
-					[]MsgWriter{s.NewMsgWriterToControllerStdout()},
-					[]MsgWriter{s.NewMsgWriterToControllerStderr()},
-				)
+			case frameWriteToConsole, frameWriteToController, frameWriteToDiscard, frameWriteToTee:
+				redirectOutput(frame.Kind, s.NewMsgWriterToControllerStdout(), s.NewMsgWriterToControllerStderr(), s.WriteSyncOptions, s.originWriteSync)
 			}
 		}
 	}()
@@ -139,6 +146,12 @@ func (s *SelfSharedConn) SetupConn() (_ <-chan *SelfSharedConnPort, err error) {
 		return nil, err
 	}
 
+	if s.CaptureConsole {
+		if err := captureConsole(mgmtPort); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create a channel to relay the event from the onmessage channel to the consuming channel,
 	// except it will close the scope itself when the parent sends a close event.
 	ch := make(chan *SelfSharedConnPort)
@@ -163,15 +176,17 @@ func (s *SelfSharedConn) SetupConn() (_ <-chan *SelfSharedConnPort, err error) {
 		close(ch)
 	}()
 
-	s.closeFunc = func() error {
-		ports := make([]*SelfSharedConnPort, len(s.ports))
-		copy(ports, s.ports)
-		for _, port := range ports {
-			port.closeFunc()
+	s.closeFunc = func(msg closeMessage) error {
+		portMsg := msg
+		portMsg.Worker = true
+		for _, port := range s.connectedPorts() {
+			port.closeFunc(portMsg)
 		}
 
 		if s.mgmtPort != nil {
-			s.mgmtPort.PostMessage(safejs.Safe(js.ValueOf(CLOSE_EVENT)), nil)
+			// Flush the buffered output before the close frame, which stops the controller from receiving the output.
+			FlushWriteSync()
+			postCloseFrame(s.mgmtPort, msg)
 		}
 
 		// This must comes after calling the closeFunc of ports, otherwise, those CLOSE events
@@ -195,24 +210,67 @@ func (s *SelfSharedConn) Location() (*types.WorkerLocation, error) {
 	return s.self.Location()
 }
 
+// ResetWriteSync flushes the buffered output, and restores the "writeSync" of the Go glue file, which writes to the console.
 func (s *SelfSharedConn) ResetWriteSync() {
-	js.Global().Get("fs").Set("writeSync", s.originWriteSync)
+	resetWriteSync(s.originWriteSync)
+}
+
+// LogHandler returns a slog.Handler that sends the log records to the controller via the mgmt port, which are handled by the LogHandler of
+// the WasmSharedWebWorkerConn that starts this worker. The records carry the level, time, message, attributes and the worker name.
+// It can only be used after SetupConn. The opts is optional, only its Level and ReplaceAttr are used.
+func (s *SelfSharedConn) LogHandler(opts *slog.HandlerOptions) slog.Handler {
+	name, _ := s.Name()
+	return newLogHandler(func() MessagePoster {
+		if s.mgmtPort == nil {
+			return nil
+		}
+		return s.mgmtPort
+	}, name, opts)
 }
 
 func (s *SelfSharedConn) NewMsgWriterToControllerStdout() MsgWriter {
-	return &msgWriterController{poster: s.mgmtPort, prefix: STDOUT_EVENT}
+	return &msgWriterController{poster: s.mgmtPort, kind: frameStdout}
 }
 
 func (s *SelfSharedConn) NewMsgWriterToControllerStderr() MsgWriter {
-	return &msgWriterController{poster: s.mgmtPort, prefix: STDERR_EVENT}
+	return &msgWriterController{poster: s.mgmtPort, kind: frameStderr}
 }
 
 // Close closes the web worker, and close the event channels on all the controllers side.
 func (s *SelfSharedConn) Close() error {
-	return s.closeFunc()
+	return s.closeFunc(closeMessage{})
+}
+
+// CloseWithReason is like Close, but attaches the code and reason to the close, which is reported by the CloseReason of
+// the WasmSharedWebWorkerMgmtConn and all the connected WasmSharedWebWorkerConn.
+func (s *SelfSharedConn) CloseWithReason(code int, reason string) error {
+	return s.closeFunc(closeMessage{Code: code, Reason: reason})
 }
 
 // Idle tells whether this Shared Web Worker has no connected port at this point
 func (s *SelfSharedConn) Idle() bool {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
 	return len(s.ports) == 0
 }
+
+func (s *SelfSharedConn) addPort(port *SelfSharedConnPort) {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	s.ports = append(s.ports, port)
+}
+
+func (s *SelfSharedConn) removePort(port *SelfSharedConnPort) {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	s.ports = slices.DeleteFunc(s.ports, func(p *SelfSharedConnPort) bool {
+		return p == port
+	})
+}
+
+// connectedPorts returns a copy of the connected ports, which are removed from the conn once closed.
+func (s *SelfSharedConn) connectedPorts() []*SelfSharedConnPort {
+	s.portsMu.Lock()
+	defer s.portsMu.Unlock()
+	return slices.Clone(s.ports)
+}
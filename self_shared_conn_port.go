@@ -4,17 +4,24 @@ package wasmww
 
 import (
 	"context"
-	"slices"
-	"syscall/js"
+	"net/http"
+	"sync"
 
 	"github.com/hack-pad/safejs"
 	"github.com/magodo/go-webworkers/types"
 )
 
 type SelfSharedConnPort struct {
+	// Codec is used to encode and decode the values for Send and Receive.
+	// If Codec is nil, JSONCodec is used.
+	Codec Codec
+
 	conn      *SelfSharedConn
-	closeFunc WebWorkerCloseFunc
+	closeFunc func(msg closeMessage) error
 	port      *types.MessagePort
+	rpc       rpcServer
+	http      httpServer
+	eventCh   <-chan types.MessageEventMessage
 }
 
 // SetupConn set up the worker port for working with the peering WasmSharedWebWorkerConn.
@@ -27,22 +34,66 @@ func (p *SelfSharedConnPort) SetupConn() (_ <-chan types.MessageEventMessage, er
 		return nil, err
 	}
 
+	// Create a channel to relay the event from the onmessage channel to the consuming channel,
+	// except the control frames, e.g. the RPC and HTTP frames, which are served by the registered handlers.
+	// The events are queued, so that the control frames are handled even if the consuming channel isn't drained.
+	queue := newEventQueue()
+	eventCh := queue.ch
+
+	// release removes this port from the conn, and closes it, once this port is closed by either side.
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.conn.removePort(p)
+			p.port.Close()
+		})
+	}
+
+	// peerClosed tells whether the peering WasmSharedWebWorkerConn closed this port.
+	var peerClosed bool
+	go func() {
+		for event := range ch {
+			frame, ok := eventControlFrame(event)
+			if !ok {
+				queue.push(event)
+				continue
+			}
+			switch frame.Kind {
+			case frameClose:
+				peerClosed = true
+				cancel()
+			case frameRPCRequest, frameRPCCancel:
+				p.rpc.dispatch(p.port, frame)
+			case frameHTTP:
+				p.http.dispatch(p.port, frame)
+			case frameNetConnEOF:
+				queue.push(event)
+			}
+		}
+		p.rpc.close()
+		p.http.close()
+		queue.close()
+		if peerClosed {
+			release()
+		}
+	}()
+
 	// Add this port to the conn's ports array for track
-	p.conn.ports = append(p.conn.ports, p)
+	p.conn.addPort(p)
 
-	p.closeFunc = func() error {
+	p.closeFunc = func(msg closeMessage) error {
 		cancel()
-		for range ch {
+		for range eventCh {
 		}
 
-		if err := p.port.PostMessage(safejs.Safe(js.ValueOf(CLOSE_EVENT)), nil); err != nil {
-			return err
+		// There is no need to notify the peer, if it has closed this port.
+		if !peerClosed {
+			if err := postCloseFrame(p.port, msg); err != nil {
+				return err
+			}
 		}
-		// Remove this port from the conn's ports array
-		p.conn.ports = slices.DeleteFunc(p.conn.ports, func(port *SelfSharedConnPort) bool {
-			return port == p
-		})
-		return p.port.Close()
+		release()
+		return nil
 	}
 
 	// Notify the controller that this worker has started listening
@@ -50,14 +101,51 @@ func (p *SelfSharedConnPort) SetupConn() (_ <-chan types.MessageEventMessage, er
 		return nil, err
 	}
 
-	return ch, nil
+	p.eventCh = eventCh
+	return eventCh, nil
 }
 
 func (p *SelfSharedConnPort) PostMessage(message safejs.Value, transfers []safejs.Value) error {
 	return p.port.PostMessage(message, transfers)
 }
 
+// EventChannel returns the channel that receives events sent from the peering WasmSharedWebWorkerConn,
+// which is the same channel returned by SetupConn().
+func (p *SelfSharedConnPort) EventChannel() <-chan types.MessageEventMessage {
+	return p.eventCh
+}
+
+// Handle registers the handler for the RPC method, which is called by the peering WasmSharedWebWorkerConn.Call.
+// The handlers can be registered either before or after SetupConn.
+func (p *SelfSharedConnPort) Handle(method string, handler RPCHandlerFunc) {
+	p.rpc.handle(method, handler)
+}
+
+// ServeHTTP serves the HTTP requests sent via the peering WasmSharedWebWorkerConn.Transport with the handler.
+// It returns immediately, each request is served in its own goroutine. The handler can be set either before or after SetupConn.
+func (p *SelfSharedConnPort) ServeHTTP(handler http.Handler) {
+	p.http.setHandler(handler)
+}
+
+// Send encodes v with the Codec, and sends it to the controller.
+func (p *SelfSharedConnPort) Send(v any) error {
+	return send(p.port, p.Codec, v)
+}
+
+// Receive receives the next event sent from the controller, and decodes it with the Codec into v.
+// It returns io.EOF once the port is closed.
+//
+// Receive consumes the event channel returned by SetupConn(), hence it shall not be used together with other consumers of the channel.
+func (p *SelfSharedConnPort) Receive(v any) error {
+	return receive(p.eventCh, p.Codec, v)
+}
+
 // Close closes this port, and close the event channel on the controller side.
 func (p *SelfSharedConnPort) Close() error {
-	return p.closeFunc()
+	return p.closeFunc(closeMessage{})
+}
+
+// CloseWithReason is like Close, but attaches the code and reason to the close, which is reported by WasmSharedWebWorkerConn.CloseReason.
+func (p *SelfSharedConnPort) CloseWithReason(code int, reason string) error {
+	return p.closeFunc(closeMessage{Code: code, Reason: reason})
 }
@@ -0,0 +1,115 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"encoding/json"
+	"syscall/js"
+	"testing"
+	"time"
+
+	"github.com/hack-pad/safejs"
+	"github.com/magodo/go-webworkers/types"
+)
+
+// newTestPort sets up a SelfSharedConnPort over a MessageChannel, and returns the port together with its peer and the events received by the peer.
+func newTestPort(t *testing.T) (*SelfSharedConnPort, *types.MessagePort, <-chan types.MessageEventMessage) {
+	t.Helper()
+	channel := js.Global().Get("MessageChannel").New()
+	port, err := types.WrapMessagePort(safejs.Safe(channel.Get("port1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := types.WrapMessagePort(safejs.Safe(channel.Get("port2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		peer.Close()
+	})
+	peerCh, err := peer.Listen(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &SelfSharedConnPort{conn: &SelfSharedConn{}, port: port}
+	if _, err := p.SetupConn(); err != nil {
+		t.Fatal(err)
+	}
+	// The initial sync event.
+	<-peerCh
+	return p, peer, peerCh
+}
+
+func TestSelfSharedConnPortControlFramesNotBlocked(t *testing.T) {
+	p, peer, peerCh := newTestPort(t)
+	p.Handle("echo", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return params, nil
+	})
+
+	// The user messages are not consumed, which must not block the RPC.
+	for i := 0; i < 3; i++ {
+		if err := peer.PostMessage(safejs.Safe(js.ValueOf(i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := newRPCClient()
+	go func() {
+		for event := range peerCh {
+			if frame, ok := eventControlFrame(event); ok && frame.Kind == frameRPCResponse {
+				client.dispatch(frame)
+			}
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var reply string
+	if err := client.call(ctx, peer, "echo", "hello", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hello" {
+		t.Errorf("got reply %q, want %q", reply, "hello")
+	}
+
+	for i := 0; i < 3; i++ {
+		data, err := (<-p.EventChannel()).Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := data.Int(); n != i {
+			t.Errorf("got the user message %d, want %d", n, i)
+		}
+	}
+}
+
+func TestSelfSharedConnPortClosedByPeer(t *testing.T) {
+	p, peer, _ := newTestPort(t)
+	if p.conn.Idle() {
+		t.Fatal("expect the conn not to be idle with a connected port")
+	}
+	if err := postControlFrame(peer, frameClose, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-p.EventChannel():
+		if ok {
+			t.Fatal("unexpected event")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event channel is not closed after the peer closes the port")
+	}
+	// The port is released right after the relay stops, which is after the event channel is closed.
+	for i := 0; !p.conn.Idle(); i++ {
+		if i == 1000 {
+			t.Fatal("the port closed by the peer is not removed from the conn")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("closing the port closed by the peer: %v", err)
+	}
+}
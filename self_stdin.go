@@ -0,0 +1,44 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"io"
+	"syscall/js"
+)
+
+// setReadStdin overrides the "read" implementation of the Go glue's fs, which by default returns ENOSYS,
+// so that reading from fd 0 (i.e. os.Stdin) reads from the stdin pipe, which is fed by the data sent from the controller.
+// It is a no-op under TinyGo, whose glue script doesn't support reading from the stdin.
+func setReadStdin(stdin io.Reader) {
+	if isTinyGo() {
+		return
+	}
+	fs := js.Global().Get("fs")
+	originRead := fs.Get("read")
+	read := js.FuncOf(func(this js.Value, args []js.Value) any {
+		// read(fd, buffer, offset, length, position, callback)
+		if args[0].Int() != 0 {
+			jsArgs := make([]any, len(args))
+			for i, arg := range args {
+				jsArgs[i] = arg
+			}
+			return originRead.Invoke(jsArgs...)
+		}
+		buffer, offset, length, callback := args[1], args[2].Int(), args[3].Int(), args[5]
+
+		// Reading from the stdin might block, hence spin up a goroutine to avoid blocking the JS event loop.
+		go func() {
+			p := make([]byte, length)
+			n, err := stdin.Read(p)
+			if err != nil {
+				// EOF is indicated by reading zero byte
+				n = 0
+			}
+			js.CopyBytesToJS(buffer.Call("subarray", offset, offset+n), p[:n])
+			callback.Invoke(js.Null(), n)
+		}()
+		return nil
+	})
+	fs.Set("read", read)
+}
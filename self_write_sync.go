@@ -3,10 +3,12 @@
 package wasmww
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"strings"
+	"sync"
 	"syscall/js"
+	"time"
 
 	"github.com/hack-pad/safejs"
 )
@@ -22,13 +24,18 @@ type MsgWriter interface {
 
 type msgWriterController struct {
 	poster MessagePoster
-	prefix string
+	kind   frameKind
 }
 
 func (msgWriterController) sealed() {}
 
+// Write sends the bytes as an Uint8Array, with its underlying buffer transferred to the controller.
 func (w *msgWriterController) Write(p []byte) (int, error) {
-	if err := w.poster.PostMessage(safejs.Safe(js.ValueOf(w.prefix+string(p))), nil); err != nil {
+	arr, transfers, err := bytesToTransferable(p)
+	if err != nil {
+		return 0, err
+	}
+	if err := postControlFrame(w.poster, w.kind, arr, transfers); err != nil {
 		return 0, err
 	}
 	return len(p), nil
@@ -53,8 +60,8 @@ type msgWriterConsole struct{}
 func (msgWriterConsole) sealed() {}
 
 func (msgWriterConsole) Write(p []byte) (int, error) {
-	p = p[:len(p)-1] // throw the newline
-	js.Global().Get("console").Call("log", js.ValueOf(string(p)))
+	msg := bytes.TrimSuffix(p, []byte("\n")) // throw the newline
+	consoleLog(string(msg))
 	return len(p), nil
 }
 
@@ -62,36 +69,311 @@ func NewMsgWriterToConsole() MsgWriter {
 	return msgWriterConsole{}
 }
 
+// FlushPolicy determines when the output buffered by the "writeSync" set by SetWriteSync is flushed to the MsgWriters.
+type FlushPolicy int
+
+const (
+	// FlushLine flushes the output up to the last newline on each write. This is the default policy.
+	FlushLine FlushPolicy = iota
+	// FlushImmediate flushes the output on each write.
+	FlushImmediate
+	// FlushSize flushes the output once the buffered output reaches WriteSyncOptions.Size.
+	FlushSize
+	// FlushInterval flushes the buffered output every WriteSyncOptions.Interval.
+	FlushInterval
+)
+
+const (
+	defaultFlushSize     = 4096
+	defaultFlushInterval = 100 * time.Millisecond
+)
+
+// WriteSyncOptions configures the buffering of the "writeSync" set by SetWriteSyncWithOptions.
+// The stdout and stderr are buffered separately, hence a partial line of one never ends up in the other.
+//
+// Regardless of the policy, the buffered output is flushed when the worker closes via SelfConn.Close or SelfSharedConn.Close,
+// when the "writeSync" is replaced or reset, or via FlushWriteSync.
+// When the Go program exits, the buffered output is sent to the controller by the worker bootstrap script
+// if it is redirected to the controller, otherwise, it is written to the console.
+type WriteSyncOptions struct {
+	Flush FlushPolicy
+
+	// Size is the threshold of the buffered output for FlushSize. Defaults to 4096.
+	Size int
+
+	// Interval is the flush interval for FlushInterval. Defaults to 100ms.
+	Interval time.Duration
+
+	// Ordered flushes the buffered output of one file descriptor before writing to the other,
+	// so that the output is flushed in the order it is written, at the cost of splitting the partial lines.
+	// It is set by SelfConn.SetupConn when the peering WasmWebWorkerConn combines the stdout and stderr.
+	Ordered bool
+}
+
+// pendingOutputVar is the JS global variable that mirrors the buffered output, so that the worker bootstrap script can flush it
+// after the Go program exits, at which point the Go functions are no longer callable.
+// It is in form of {controller: <bool>, discard: <bool>, chunks: [{fd: <fd>, data: <Uint8Array>}...]}.
+const pendingOutputVar = "__wasmww_pending_output__"
+
+// orderedOutputVar is the JS global variable set by the worker bootstrap script, which tells whether the controller combines the stdout and stderr.
+const orderedOutputVar = "__wasmww_ordered_output__"
+
+var (
+	activeWriteSyncMu sync.Mutex
+	activeWriteSync   *writeSyncer
+)
+
+// writeSyncer buffers the output written by Go, and flushes it to the MsgWriters according to the options.
+type writeSyncer struct {
+	opts WriteSyncOptions
+
+	// mu guards the fields below.
+	mu      sync.Mutex
+	stdout  fdBuffer
+	stderr  fdBuffer
+	timer   *time.Timer
+	pending js.Value
+
+	// controller and console tell whether the output is written to the controller and the console respectively,
+	// and discard tells whether the output is written to nowhere.
+	controller bool
+	console    bool
+	discard    bool
+
+	// outbox is the flushed output to be written to the MsgWriters, which is written without holding the mu,
+	// so that a MsgWriter writing to the stdout/stderr again, e.g. a logger targeting os.Stderr, doesn't deadlock.
+	// Only the one that sets the writing writes the outbox, so that the output is still written in order.
+	outbox  []flushedOutput
+	writing bool
+}
+
+// flushedOutput is the output flushed from the buffer of a file descriptor.
+type flushedOutput struct {
+	b    *fdBuffer
+	data []byte
+}
+
+// fdBuffer is the buffered output of a file descriptor.
+type fdBuffer struct {
+	fd      int
+	name    string
+	writers []MsgWriter
+	buf     []byte
+}
+
 // SetWriteSync overrides the "writeSync" implementation that will be called by Go.
 // It redirects the message to a slice of `MsgWriterFunc` functions for both the stdout and stderr.
+// The output is flushed by line, see SetWriteSyncWithOptions for other policies.
 func SetWriteSync(stdoutWriters, stderrWriters []MsgWriter) {
-	writeSync := func() js.Func {
-		jsConsole := js.Global().Get("console")
-		var outputBuffer string
-		return js.FuncOf(func(this js.Value, args []js.Value) any {
-			fd, buf := args[0], args[1]
-			outputBuffer += js.Global().Get("TextDecoder").New("utf-8").Call("decode", buf).String()
-			nl := strings.LastIndex(outputBuffer, "\n")
-			if nl != -1 {
-				msg := outputBuffer[:nl+1] // also write the newline (especially, the console writer will further throw it)
-				switch fd.Int() {
-				case 1:
-					for i, w := range stdoutWriters {
-						if _, err := w.Write([]byte(msg)); err != nil {
-							jsConsole.Call("log", js.ValueOf(fmt.Sprintf("%d-th writeSync for stdout error: %v", i, err)))
-						}
-					}
-				case 2:
-					for i, w := range stderrWriters {
-						if _, err := w.Write([]byte(msg)); err != nil {
-							jsConsole.Call("log", js.ValueOf(fmt.Sprintf("%d-th writeSync for stdout error: %v", i, err)))
-						}
-					}
+	SetWriteSyncWithOptions(stdoutWriters, stderrWriters, WriteSyncOptions{})
+}
+
+// SetWriteSyncWithOptions is like SetWriteSync, but flushes the output according to the opts.
+// The output buffered by the previous "writeSync" set by this package, if any, is flushed first.
+func SetWriteSyncWithOptions(stdoutWriters, stderrWriters []MsgWriter, opts WriteSyncOptions) {
+	s := newWriteSyncer(stdoutWriters, stderrWriters, opts)
+
+	activeWriteSyncMu.Lock()
+	defer activeWriteSyncMu.Unlock()
+	if activeWriteSync != nil {
+		activeWriteSync.stop()
+	}
+	activeWriteSync = s
+	js.Global().Set(pendingOutputVar, s.pending)
+	setWriteSyncFunc(js.FuncOf(s.writeSync).Value)
+}
+
+func newWriteSyncer(stdoutWriters, stderrWriters []MsgWriter, opts WriteSyncOptions) *writeSyncer {
+	s := &writeSyncer{
+		opts:   opts,
+		stdout: fdBuffer{fd: 1, name: "stdout", writers: stdoutWriters},
+		stderr: fdBuffer{fd: 2, name: "stderr", writers: stderrWriters},
+	}
+	for _, w := range append(append([]MsgWriter{}, stdoutWriters...), stderrWriters...) {
+		switch w.(type) {
+		case *msgWriterController:
+			s.controller = true
+		case msgWriterConsole:
+			s.console = true
+		}
+	}
+	s.discard = len(stdoutWriters) == 0 && len(stderrWriters) == 0
+	s.pending = js.ValueOf(map[string]any{"controller": s.controller, "discard": s.discard, "chunks": []any{}})
+	return s
+}
+
+// FlushWriteSync flushes the output buffered by the "writeSync" set by SetWriteSync, if any.
+func FlushWriteSync() {
+	activeWriteSyncMu.Lock()
+	defer activeWriteSyncMu.Unlock()
+	if activeWriteSync != nil {
+		activeWriteSync.mu.Lock()
+		activeWriteSync.flushAll()
+		activeWriteSync.mu.Unlock()
+		activeWriteSync.writeOutbox()
+	}
+}
+
+// resetWriteSync flushes and stops the "writeSync" set by SetWriteSync, if any, and restores the origin one.
+func resetWriteSync(originWriteSync js.Value) {
+	activeWriteSyncMu.Lock()
+	defer activeWriteSyncMu.Unlock()
+	if activeWriteSync != nil {
+		activeWriteSync.stop()
+		activeWriteSync = nil
+	}
+	js.Global().Delete(pendingOutputVar)
+	setWriteSyncFunc(originWriteSync)
+}
+
+// outputTargets tells whether the output is written to the controller, the console, or nowhere, by the active "writeSync".
+func outputTargets() (controller, console, discard bool) {
+	activeWriteSyncMu.Lock()
+	defer activeWriteSyncMu.Unlock()
+	if activeWriteSync == nil {
+		// The "writeSync" of the Go glue file writes to the console.
+		return false, true, false
+	}
+	return activeWriteSync.controller, activeWriteSync.console, activeWriteSync.discard
+}
+
+func (s *writeSyncer) writeSync(this js.Value, args []js.Value) any {
+	fd, buf := args[0].Int(), args[1]
+
+	var b, other *fdBuffer
+	switch fd {
+	case 1:
+		b, other = &s.stdout, &s.stderr
+	case 2:
+		b, other = &s.stderr, &s.stdout
+	default:
+		return buf.Get("length")
+	}
+
+	p := make([]byte, buf.Length())
+	js.CopyBytesToGo(p, buf)
+
+	s.mu.Lock()
+	if s.opts.Ordered {
+		s.flush(other, len(other.buf))
+	}
+
+	b.buf = append(b.buf, p...)
+	s.pending.Get("chunks").Call("push", js.ValueOf(map[string]any{"fd": fd, "data": buf.Call("slice")}))
+
+	switch s.opts.Flush {
+	case FlushImmediate:
+		s.flush(b, len(b.buf))
+	case FlushSize:
+		size := s.opts.Size
+		if size <= 0 {
+			size = defaultFlushSize
+		}
+		if len(b.buf) >= size {
+			s.flush(b, len(b.buf))
+		}
+	case FlushInterval:
+		if s.timer == nil {
+			interval := s.opts.Interval
+			if interval <= 0 {
+				interval = defaultFlushInterval
+			}
+			s.timer = time.AfterFunc(interval, func() {
+				s.mu.Lock()
+				s.timer = nil
+				s.flushAll()
+				s.mu.Unlock()
+				s.writeOutbox()
+			})
+		}
+	default:
+		if nl := bytes.LastIndexByte(b.buf, '\n'); nl != -1 {
+			// also write the newline (especially, the console writer will further throw it)
+			s.flush(b, nl+1)
+		}
+	}
+	s.mu.Unlock()
+	s.writeOutbox()
+	return buf.Get("length")
+}
+
+// flush moves the first n bytes of the buffer to the outbox, which is written by writeOutbox. It must be called with s.mu held.
+func (s *writeSyncer) flush(b *fdBuffer, n int) {
+	if n == 0 {
+		return
+	}
+	s.outbox = append(s.outbox, flushedOutput{b: b, data: b.buf[:n:n]})
+	b.buf = append([]byte(nil), b.buf[n:]...)
+
+	// Re-mirror the remaining output. At most one of the buffers is non-empty in the ordered mode, so the order is kept.
+	chunks := []any{}
+	for _, b := range []*fdBuffer{&s.stdout, &s.stderr} {
+		if len(b.buf) != 0 {
+			arr := js.Global().Get("Uint8Array").New(len(b.buf))
+			js.CopyBytesToJS(arr, b.buf)
+			chunks = append(chunks, map[string]any{"fd": b.fd, "data": arr})
+		}
+	}
+	s.pending.Set("chunks", js.ValueOf(chunks))
+}
+
+// writeOutbox writes the outbox to the MsgWriters, unless it is being written by another call, which then writes the output added meanwhile.
+// It must be called without s.mu held.
+func (s *writeSyncer) writeOutbox() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writing {
+		return
+	}
+	s.writing = true
+	for len(s.outbox) != 0 {
+		outbox := s.outbox
+		s.outbox = nil
+		s.mu.Unlock()
+		for _, o := range outbox {
+			for i, w := range o.b.writers {
+				if _, err := w.Write(o.data); err != nil {
+					js.Global().Get("console").Call("log", js.ValueOf(fmt.Sprintf("%d-th writeSync for %s error: %v", i, o.b.name, err)))
 				}
-				outputBuffer = outputBuffer[nl+1:]
 			}
-			return buf.Get("length")
-		})
-	}()
-	js.Global().Get("fs").Set("writeSync", writeSync)
+		}
+		s.mu.Lock()
+	}
+	s.writing = false
+}
+
+// flushAll flushes the buffered output of both the stdout and stderr. It must be called with s.mu held.
+func (s *writeSyncer) flushAll() {
+	s.flush(&s.stdout, len(s.stdout.buf))
+	s.flush(&s.stderr, len(s.stderr.buf))
+}
+
+// stop flushes the buffered output, and stops the flush timer, if any.
+func (s *writeSyncer) stop() {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.flushAll()
+	s.mu.Unlock()
+	s.writeOutbox()
+}
+
+// redirectOutput redirects the stdout/stderr of the worker as instructed by the write-to-* frame sent from the controller.
+func redirectOutput(kind frameKind, stdout, stderr MsgWriter, opts WriteSyncOptions, originWriteSync js.Value) {
+	switch kind {
+	case frameWriteToConsole:
+		resetWriteSync(originWriteSync)
+	case frameWriteToController:
+		SetWriteSyncWithOptions([]MsgWriter{stdout}, []MsgWriter{stderr}, opts)
+	case frameWriteToDiscard:
+		SetWriteSyncWithOptions(nil, nil, opts)
+	case frameWriteToTee:
+		SetWriteSyncWithOptions(
+			[]MsgWriter{stdout, NewMsgWriterToConsole()},
+			[]MsgWriter{stderr, NewMsgWriterToConsole()},
+			opts,
+		)
+	}
 }
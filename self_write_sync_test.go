@@ -0,0 +1,326 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall/js"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/hack-pad/safejs"
+)
+
+// outputRecorder records the writes to the MsgWriters of the stdout and stderr, in the order they are written.
+type outputRecorder struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (r *outputRecorder) writer(fd int) MsgWriter {
+	return NewMsgWriterToIoWriter(writerFunc(func(p []byte) (int, error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.writes = append(r.writes, fmt.Sprintf("%d:%s", fd, p))
+		return len(p), nil
+	}))
+}
+
+func (r *outputRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.writes...)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// newTestWriteSyncer returns a writeSyncer writing to the recorder, without replacing the "writeSync" of the test binary.
+func newTestWriteSyncer(opts WriteSyncOptions) (*writeSyncer, *outputRecorder) {
+	r := &outputRecorder{}
+	return newWriteSyncer([]MsgWriter{r.writer(1)}, []MsgWriter{r.writer(2)}, opts), r
+}
+
+// write calls the writeSync as the Go glue file does.
+func (s *writeSyncer) write(fd int, data string) {
+	buf := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(buf, []byte(data))
+	s.writeSync(js.Null(), []js.Value{js.ValueOf(fd), buf})
+}
+
+func TestWriteSyncFlushPolicy(t *testing.T) {
+	cases := map[string]struct {
+		opts   WriteSyncOptions
+		writes []string
+		// want is the output flushed after the writes, and wantStop is the one flushed by the stop, e.g. when the worker closes.
+		want     []string
+		wantStop []string
+	}{
+		"line": {
+			opts:     WriteSyncOptions{Flush: FlushLine},
+			writes:   []string{"a", "b\nc", "d\ne\n", "partial"},
+			want:     []string{"1:ab\n", "1:cd\ne\n"},
+			wantStop: []string{"1:partial"},
+		},
+		"immediate": {
+			opts:   WriteSyncOptions{Flush: FlushImmediate},
+			writes: []string{"a", "b\nc"},
+			want:   []string{"1:a", "1:b\nc"},
+		},
+		"size": {
+			opts:     WriteSyncOptions{Flush: FlushSize, Size: 4},
+			writes:   []string{"ab", "c\nde", "f"},
+			want:     []string{"1:abc\nde"},
+			wantStop: []string{"1:f"},
+		},
+		"interval without stop": {
+			opts:     WriteSyncOptions{Flush: FlushInterval, Interval: time.Hour},
+			writes:   []string{"no newline"},
+			wantStop: []string{"1:no newline"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, r := newTestWriteSyncer(c.opts)
+			for _, w := range c.writes {
+				s.write(1, w)
+			}
+			if got := r.get(); strings.Join(got, "|") != strings.Join(c.want, "|") {
+				t.Errorf("got flushed %q, want %q", got, c.want)
+			}
+			s.stop()
+			want := append(append([]string(nil), c.want...), c.wantStop...)
+			if got := r.get(); strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("got flushed %q after stop, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteSyncFlushInterval(t *testing.T) {
+	s, r := newTestWriteSyncer(WriteSyncOptions{Flush: FlushInterval, Interval: 10 * time.Millisecond})
+	defer s.stop()
+	s.write(1, "no ")
+	s.write(2, "err")
+	s.write(1, "newline")
+	if got := r.get(); len(got) != 0 {
+		t.Fatalf("expect nothing flushed before the interval, got %q", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.get()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := r.get(), []string{"1:no newline", "2:err"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got flushed %q, want %q", got, want)
+	}
+}
+
+func TestWriteSyncOrdered(t *testing.T) {
+	writes := []struct {
+		fd   int
+		data string
+	}{
+		{1, "out1 "},
+		{2, "err1\n"},
+		{1, "out2\n"},
+		{2, "err2 "},
+		{2, "err3\n"},
+		{1, "out3"},
+	}
+	cases := map[string]struct {
+		ordered bool
+		want    []string
+	}{
+		// The partial line of one file descriptor is flushed before the other is written, so the output keeps the order it is written.
+		"ordered": {
+			ordered: true,
+			want:    []string{"1:out1 ", "2:err1\n", "1:out2\n", "2:err2 err3\n", "1:out3"},
+		},
+		// Otherwise, the partial lines are kept intact, while stdout and stderr are out of order.
+		"unordered": {
+			want: []string{"2:err1\n", "1:out1 out2\n", "2:err2 err3\n", "1:out3"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, r := newTestWriteSyncer(WriteSyncOptions{Ordered: c.ordered})
+			for _, w := range writes {
+				s.write(w.fd, w.data)
+			}
+			s.stop()
+			if got := r.get(); strings.Join(got, "|") != strings.Join(c.want, "|") {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWriteSyncReentrant(t *testing.T) {
+	r := &outputRecorder{}
+	var s *writeSyncer
+	// The stdout writer writes to the stderr again, like a tee, or a logger targeting os.Stderr.
+	stdout := NewMsgWriterToIoWriter(writerFunc(func(p []byte) (int, error) {
+		s.write(2, "logged: "+string(p))
+		return r.writer(1).Write(p)
+	}))
+	s = newWriteSyncer([]MsgWriter{stdout}, []MsgWriter{r.writer(2)}, WriteSyncOptions{Flush: FlushImmediate})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.write(1, "hello")
+		s.write(1, "world")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writeSync deadlocks")
+	}
+	if got, want := r.get(), []string{"1:hello", "2:logged: hello", "1:world", "2:logged: world"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// evalFlushPendingOutput evaluates the output partial of the bootstrap scripts, and returns its flushPendingOutput reading the pending output of s.
+func evalFlushPendingOutput(t *testing.T, s *writeSyncer) js.Value {
+	t.Helper()
+	var script bytes.Buffer
+	tpl := template.Must(template.New("output").Parse(string(OutputJSTpl)))
+	if err := tpl.Execute(&script, templateData{PendingOutputVar: pendingOutputVar, StdoutKind: string(frameStdout), StderrKind: string(frameStderr)}); err != nil {
+		t.Fatal(err)
+	}
+	controlFrame := `function controlFrame(kind, payload) { return {"` + envelopeMarker + `": ` + fmt.Sprint(ProtocolVersion) + `, kind: kind, payload: payload}; }`
+	self := js.Global().Get("Object").New()
+	self.Set(pendingOutputVar, s.pending)
+	fn, err := safejs.Safe(js.Global().Get("Function")).New("self", controlFrame+"\n"+script.String()+"\nreturn flushPendingOutput;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flush, err := fn.Invoke(safejs.Safe(self))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return safejs.Unsafe(flush)
+}
+
+func TestFlushPendingOutput(t *testing.T) {
+	// The output to the controller, which is still buffered when the Go program exits, is flushed by the bootstrap script.
+	poster := &recordPoster{}
+	s := newWriteSyncer(
+		[]MsgWriter{&msgWriterController{poster: poster, kind: frameStdout}},
+		[]MsgWriter{&msgWriterController{poster: poster, kind: frameStderr}},
+		WriteSyncOptions{},
+	)
+	s.write(1, "flushed\npartial ")
+	s.write(1, "line")
+	s.write(2, "err")
+
+	var posted []string
+	post := js.FuncOf(func(this js.Value, args []js.Value) any {
+		frame, ok := parseControlFrame(safejs.Safe(args[0]))
+		if !ok {
+			t.Errorf("the posted message is not a control frame")
+			return nil
+		}
+		b, err := bytesFromJS(frame.Payload)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		posted = append(posted, fmt.Sprintf("%s:%s", frame.Kind, b))
+		return nil
+	})
+	defer post.Release()
+	evalFlushPendingOutput(t, s).Invoke(post)
+
+	var flushed []string
+	for _, frame := range poster.frames(t) {
+		b, err := bytesFromJS(frame.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flushed = append(flushed, fmt.Sprintf("%s:%s", frame.Kind, b))
+	}
+	if want := []string{"stdout:flushed\n"}; strings.Join(flushed, "|") != strings.Join(want, "|") {
+		t.Errorf("got flushed %q by Go, want %q", flushed, want)
+	}
+	// The consecutive chunks of the stdout are merged.
+	if want := []string{"stdout:partial line", "stderr:err"}; strings.Join(posted, "|") != strings.Join(want, "|") {
+		t.Errorf("got flushed %q by the bootstrap script, want %q", posted, want)
+	}
+
+	// The output is flushed only once.
+	posted = nil
+	evalFlushPendingOutput(t, s).Invoke(post)
+	if len(posted) != 0 {
+		t.Errorf("expect nothing flushed again, got %q", posted)
+	}
+}
+
+func TestRedirectOutput(t *testing.T) {
+	origin := getWriteSyncFunc()
+	t.Cleanup(func() {
+		resetWriteSync(origin)
+	})
+
+	cases := []struct {
+		kind                         frameKind
+		controller, console, discard bool
+	}{
+		{kind: frameWriteToController, controller: true},
+		{kind: frameWriteToDiscard, discard: true},
+		{kind: frameWriteToTee, controller: true, console: true},
+		{kind: frameWriteToConsole, console: true},
+	}
+	for _, c := range cases {
+		t.Run(string(c.kind), func(t *testing.T) {
+			poster := &recordPoster{}
+			stdout := &msgWriterController{poster: poster, kind: frameStdout}
+			stderr := &msgWriterController{poster: poster, kind: frameStderr}
+			redirectOutput(c.kind, stdout, stderr, WriteSyncOptions{Flush: FlushImmediate}, origin)
+
+			controller, console, discard := outputTargets()
+			if controller != c.controller || console != c.console || discard != c.discard {
+				t.Errorf("got the output targets (controller: %t, console: %t, discard: %t), want (%t, %t, %t)",
+					controller, console, discard, c.controller, c.console, c.discard)
+			}
+			if c.kind == frameWriteToConsole {
+				if !getWriteSyncFunc().Equal(origin) {
+					t.Error("expect the origin writeSync to be restored")
+				}
+				return
+			}
+
+			activeWriteSyncMu.Lock()
+			s := activeWriteSync
+			activeWriteSyncMu.Unlock()
+			// The console writer of the tee mode is not exercised, to keep the test output clean.
+			if !c.console {
+				s.write(1, "out")
+				s.write(2, "err")
+			}
+			var got []string
+			for _, frame := range poster.frames(t) {
+				b, err := bytesFromJS(frame.Payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, fmt.Sprintf("%s:%s", frame.Kind, b))
+			}
+			var want []string
+			if c.controller && !c.console {
+				want = []string{"stdout:out", "stderr:err"}
+			}
+			if strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("got the output sent to the controller %q, want %q", got, want)
+			}
+		})
+	}
+}
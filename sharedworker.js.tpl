@@ -1,14 +1,113 @@
+const ports = [];
+
+// Wrap the payload into the envelope of the control frames, which is distinguished from the user messages.
+function controlFrame(kind, payload) {
+    return {"{{.EnvelopeMarker}}": {{.ProtocolVersion}}, kind: kind, payload: payload};
+}
+
+// startError records the failure of starting the WASM, which is reported to every connected port.
+let startError = null;
+
+{{template "output" .}}
+
 addEventListener("connect", (e) => {
     const port = e.ports[0];
     self.recent_port = port;
+    ports.push(port);
     port.start();
+    if (startError !== null) {
+        port.postMessage(startError);
+    }
 });
 
-importScripts(location.origin + '/wasm_exec.js');
+function startFailed(stage, err) {
+    startError = controlFrame("{{.StartErrorKind}}", JSON.stringify({stage: stage, message: String(err)}));
+    for (const port of ports) {
+        port.postMessage(startError);
+    }
+}
+
+try {
+    importScripts({{.GlueToJS}});
+} catch (err) {
+    startFailed("glue", err);
+    throw err;
+}
 
 const go = new Go();
 go.argv = {{.ArgsToJS}}
 go.env = {{.EnvToJS}}
-WebAssembly.instantiateStreaming(fetch("{{.Path}}"), go.importObject).then((result) => {
-    go.run(result.instance);
+{{- if .TinyGo}}
+
+{{template "tinygo" .}}
+{{- end}}
+
+// Report the exit code of the Go program to all the connected ports, then close this worker.
+// The glue script of TinyGo has no go.exit, hence it is called by the wrapped proc_exit instead.
+let exited = false;
+const goExit = go.exit;
+go.exit = (code) => {
+    exited = true;
+    if (goExit) {
+        goExit(code);
+    }
+    // Only the mgmt port consumes the output, the other ports ignore it.
+    flushPendingOutput((msg) => {
+        for (const port of ports) {
+            port.postMessage(msg);
+        }
+    });
+    for (const port of ports) {
+        port.postMessage(controlFrame("{{.ExitKind}}", code));
+    }
+    self.close();
+};
+
+// Report the crash of the WASM without exiting, e.g. a WebAssembly trap, to all the connected ports, then close this worker.
+// The trap either rejects the promise returned by go.run, or is thrown from an event handler calling into the Go program.
+let crashed = false;
+function crash(err) {
+    if (crashed || exited) {
+        return;
+    }
+    crashed = true;
+    flushPendingOutput((msg) => {
+        for (const port of ports) {
+            port.postMessage(msg);
+        }
+    });
+    for (const port of ports) {
+        port.postMessage(controlFrame("{{.CrashKind}}", String(err)));
+    }
+    self.close();
+}
+addEventListener("error", (e) => {
+    if (e.error instanceof WebAssembly.RuntimeError) {
+        crash(e.error);
+    }
 });
+
+{{template "console" .}}
+
+(async () => {
+    let resp, module, instance;
+    try {
+        resp = await fetch({{.PathToJS}});
+        if (!resp.ok) {
+            throw new Error(`${resp.status} ${resp.statusText}`);
+        }
+    } catch (err) {
+        return startFailed("fetch", err);
+    }
+    try {
+        module = await WebAssembly.compileStreaming(resp);
+    } catch (err) {
+        return startFailed("compile", err);
+    }
+    try {
+        instance = await WebAssembly.instantiate(module, go.importObject);
+    } catch (err) {
+        return startFailed("link", err);
+    }
+    go.run(instance).catch(crash);
+})();
@@ -0,0 +1,65 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/magodo/go-webworkers/types"
+)
+
+// StartStage is the stage of the worker bootstrap script, at which the worker fails to start.
+type StartStage string
+
+const (
+	// StartStageGlue means the worker fails to load the Go glue script (i.e. wasm_exec.js).
+	StartStageGlue StartStage = "glue"
+	// StartStageFetch means the worker fails to fetch the WASM.
+	StartStageFetch StartStage = "fetch"
+	// StartStageCompile means the worker fails to compile the fetched WASM.
+	StartStageCompile StartStage = "compile"
+	// StartStageLink means the worker fails to instantiate the compiled WASM, e.g. due to missing imports.
+	StartStageLink StartStage = "link"
+)
+
+// StartError is returned by Start when the worker bootstrap script fails to start the WASM.
+type StartError struct {
+	Stage   StartStage `json:"stage"`
+	Message string     `json:"message"`
+}
+
+func (e *StartError) Error() string {
+	return fmt.Sprintf("wasmww: worker failed at the %s stage: %s", e.Stage, e.Message)
+}
+
+// parseStartErrorFrame parses the start error frame sent by the worker bootstrap script, whose payload is the JSON encoded StartError.
+func parseStartErrorFrame(frame controlFrame) (*StartError, bool) {
+	if frame.Kind != frameStartError {
+		return nil, false
+	}
+	var startErr StartError
+	if err := frame.unmarshal(&startErr); err != nil {
+		return nil, false
+	}
+	return &startErr, true
+}
+
+// waitSyncEvent waits for the initial sync event from the worker, until the ctx is done.
+// If the worker reports a failure of starting the WASM instead, a *StartError is returned.
+func waitSyncEvent(ctx context.Context, ch <-chan types.MessageEventMessage) (types.MessageEventMessage, error) {
+	select {
+	case event, ok := <-ch:
+		if !ok {
+			return event, fmt.Errorf("message event channel closed (due to ctx canceled)")
+		}
+		if frame, ok := eventControlFrame(event); ok {
+			if startErr, ok := parseStartErrorFrame(frame); ok {
+				return event, startErr
+			}
+		}
+		return event, nil
+	case <-ctx.Done():
+		return types.MessageEventMessage{}, fmt.Errorf("waiting for the worker to start: %w", ctx.Err())
+	}
+}
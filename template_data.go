@@ -5,7 +5,8 @@ package wasmww
 import (
 	"bytes"
 	_ "embed"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"net/url"
 	"os"
 	"strings"
@@ -16,18 +17,73 @@ import (
 //go:embed worker.js.tpl
 var WorkerJSTpl []byte
 
+//go:embed workerconn.js.tpl
+var WorkerConnJSTpl []byte
+
 //go:embed sharedworker.js.tpl
 var SharedWorkerJSTpl []byte
 
-func buildWorkerJS(args, env []string, path string) (string, error) {
-	return buildJS(args, env, path, WorkerJSTpl)
+//go:embed wasiconn.js.tpl
+var WASIConnJSTpl []byte
+
+// TinyGoJSTpl is included by the other templates for RuntimeTinyGo.
+//
+//go:embed tinygo.js.tpl
+var TinyGoJSTpl []byte
+
+// OutputJSTpl is included by the templates of the connections, which flushes the output pending when the Go program exits.
+//
+//go:embed output.js.tpl
+var OutputJSTpl []byte
+
+// ConsoleJSTpl is included by the templates of the connections, which supports capturing the console output.
+//
+//go:embed console.js.tpl
+var ConsoleJSTpl []byte
+
+func buildWorkerJS(args, env []string, path string, opts bootstrapOptions) (string, error) {
+	if opts.Runtime == RuntimeWASI {
+		return "", errUnsupportedWASI
+	}
+	return buildJS(args, env, path, WorkerJSTpl, opts)
+}
+
+var errUnsupportedWASI = errors.New("wasmww: RuntimeWASI is only supported by WasmWebWorkerConn")
+
+// bootstrapOptions are the options of the worker bootstrap script.
+type bootstrapOptions struct {
+	// GlueURL is the URL of the Go glue script. If empty, the "/wasm_exec.js" of the current origin is used.
+	GlueURL string
+
+	// GlueJS is the content of the Go glue script, which takes precedence over the GlueURL.
+	GlueJS string
+
+	// Runtime is the toolchain that the WASM is built with.
+	Runtime Runtime
+
+	// SharedModule tells the worker to instantiate the Module sent from the controller, instead of fetching and compiling the WASM.
+	SharedModule bool
+
+	// OrderedOutput tells the Go program in the worker to flush its stdout/stderr in the order they are written.
+	// It is only set by the connections.
+	OrderedOutput bool
+}
+
+func buildWorkerConnJS(args, env []string, path string, opts bootstrapOptions) (string, error) {
+	if opts.Runtime == RuntimeWASI {
+		return buildJS(args, env, path, WASIConnJSTpl, opts)
+	}
+	return buildJS(args, env, path, WorkerConnJSTpl, opts)
 }
 
-func buildSharedWorkerJS(args, env []string, path string) (string, error) {
-	return buildJS(args, env, path, SharedWorkerJSTpl)
+func buildSharedWorkerJS(args, env []string, path string, opts bootstrapOptions) (string, error) {
+	if opts.Runtime == RuntimeWASI {
+		return "", errUnsupportedWASI
+	}
+	return buildJS(args, env, path, SharedWorkerJSTpl, opts)
 }
 
-func buildJS(args, env []string, path string, tpl []byte) (string, error) {
+func buildJS(args, env []string, path string, tpl []byte, opts bootstrapOptions) (string, error) {
 	var workerJS bytes.Buffer
 
 	if len(args) == 0 {
@@ -38,46 +94,139 @@ func buildJS(args, env []string, path string, tpl []byte) (string, error) {
 		env = os.Environ()
 	}
 
-	if uRL, err := url.ParseRequestURI(path); err != nil || !uRL.IsAbs() {
-		origin := js.Global().Get("location").Get("origin").String()
-		baseURL, err := url.ParseRequestURI(origin)
-		if err != nil {
+	path, err := absoluteURL(path)
+	if err != nil {
+		return "", err
+	}
+
+	glueURL := opts.GlueURL
+	if glueURL != "" {
+		if glueURL, err = absoluteURL(glueURL); err != nil {
 			return "", err
 		}
-		path = baseURL.JoinPath(path).String()
 	}
 
 	data := templateData{
-		Path: path,
-		Args: args,
-		Env:  env,
+		Path:              path,
+		GlueURL:           glueURL,
+		GlueJS:            opts.GlueJS,
+		TinyGo:            opts.Runtime == RuntimeTinyGo,
+		SharedModule:      opts.SharedModule,
+		ModuleKind:        string(frameModule),
+		WriteSyncVar:      writeSyncVar,
+		Args:              args,
+		Env:               env,
+		EnvelopeMarker:    envelopeMarker,
+		ProtocolVersion:   ProtocolVersion,
+		ExitKind:          string(frameExit),
+		CrashKind:         string(frameCrash),
+		StartErrorKind:    string(frameStartError),
+		StdoutKind:        string(frameStdout),
+		StderrKind:        string(frameStderr),
+		PendingOutputVar:  pendingOutputVar,
+		OrderedOutputVar:  orderedOutputVar,
+		OrderedOutput:     opts.OrderedOutput,
+		CaptureConsoleVar: captureConsoleVar,
+		ConsoleOriginVar:  consoleOriginVar,
 	}
-	if err := template.Must(template.New("js").Parse(string(tpl))).Execute(&workerJS, data); err != nil {
+	t := template.Must(template.New("js").Parse(string(tpl)))
+	template.Must(t.New("tinygo").Parse(string(TinyGoJSTpl)))
+	template.Must(t.New("output").Parse(string(OutputJSTpl)))
+	template.Must(t.New("console").Parse(string(ConsoleJSTpl)))
+	if err := t.ExecuteTemplate(&workerJS, "js", data); err != nil {
 		return "", err
 	}
 	return workerJS.String(), nil
 }
 
+// absoluteURL resolves the path against the origin of the current page, unless it is an absolute URL already.
+// This is necessary as the worker script is loaded from a blob URL, against which a relative path can't be resolved.
+func absoluteURL(path string) (string, error) {
+	if uRL, err := url.ParseRequestURI(path); err == nil && uRL.IsAbs() {
+		return path, nil
+	}
+	origin := js.Global().Get("location").Get("origin").String()
+	baseURL, err := url.ParseRequestURI(origin)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.JoinPath(path).String(), nil
+}
+
 type templateData struct {
-	Path string
-	Args []string
-	Env  []string
+	Path              string
+	GlueURL           string
+	GlueJS            string
+	TinyGo            bool
+	SharedModule      bool
+	ModuleKind        string
+	WriteSyncVar      string
+	Args              []string
+	Env               []string
+	EnvelopeMarker    string
+	ProtocolVersion   int
+	ExitKind          string
+	CrashKind         string
+	StartErrorKind    string
+	StdoutKind        string
+	StderrKind        string
+	PendingOutputVar  string
+	OrderedOutputVar  string
+	OrderedOutput     bool
+	CaptureConsoleVar string
+	ConsoleOriginVar  string
 }
 
-func (d templateData) ArgsToJS() string {
-	el := []string{}
-	for _, e := range d.Args {
-		el = append(el, `"`+e+`"`)
+// The values below are serialized as JSON, which is a valid JS expression. Besides the quotes, backslashes and control characters,
+// json.Marshal also escapes "<", ">", "&", U+2028 and U+2029, so that the values can't break out of the string literals,
+// even if the script is embedded in HTML.
+
+// PathToJS returns the Path as a JS string literal.
+func (d templateData) PathToJS() (string, error) {
+	return toJS(d.Path)
+}
+
+// GlueToJS returns the JS expression of the URL to import the Go glue script from.
+// The GlueJS, if any, is imported from a blob URL, so that a failure of evaluating it is reported as the glue stage of the StartError.
+func (d templateData) GlueToJS() (string, error) {
+	switch {
+	case d.GlueJS != "":
+		glue, err := toJS(d.GlueJS)
+		if err != nil {
+			return "", err
+		}
+		return `URL.createObjectURL(new Blob([` + glue + `], {type: "text/javascript"}))`, nil
+	case d.GlueURL != "":
+		return toJS(d.GlueURL)
+	default:
+		return `location.origin + "/wasm_exec.js"`, nil
+	}
+}
+
+// ArgsToJS returns the Args as a JS array literal.
+func (d templateData) ArgsToJS() (string, error) {
+	args := d.Args
+	if args == nil {
+		args = []string{}
 	}
-	return "[" + strings.Join(el, ",") + "]"
+	return toJS(args)
 }
 
-func (d templateData) EnvToJS() string {
-	el := []string{}
+// EnvToJS returns the Env as a JS object literal. For the duplicate keys, the last value wins. The entries without "=" are ignored.
+func (d templateData) EnvToJS() (string, error) {
+	env := map[string]string{}
 	for _, entry := range d.Env {
 		if k, v, ok := strings.Cut(entry, "="); ok {
-			el = append(el, fmt.Sprintf(`"%s":"%s"`, k, v))
+			env[k] = v
 		}
 	}
-	return "{" + strings.Join(el, ",") + "}"
+	return toJS(env)
+}
+
+func toJS(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
 }
@@ -0,0 +1,183 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"reflect"
+	"strings"
+	"syscall/js"
+	"testing"
+
+	"github.com/hack-pad/safejs"
+)
+
+var adversarialStrings = []string{
+	"",
+	"plain",
+	`"`,
+	`'`,
+	"`${globalThis.pwned = true}`",
+	`\`,
+	`\"`,
+	"\n\r\t\b\f\v\x00\x1f",
+	"</script><script>globalThis.pwned = true</script>",
+	"<!-- -->",
+	"\"); globalThis.pwned = true; (\"",
+	"\"]; globalThis.pwned = true; [\"",
+	"\"}; globalThis.pwned = true; ({\"",
+	"\u2028\u2029",
+	"日本語 🚀",
+	"\xff\xfe",
+}
+
+// validUTF8 replaces each invalid byte of s with U+FFFD, as json.Marshal does.
+func validUTF8(s string) string {
+	return string([]rune(s))
+}
+
+// evalJS evaluates the JS expression, and fails the test if it throws.
+func evalJS(t *testing.T, expr string) js.Value {
+	t.Helper()
+	fn, err := safejs.Safe(js.Global().Get("Function")).New("return (" + expr + ");")
+	if err != nil {
+		t.Fatalf("parsing %q: %v", expr, err)
+	}
+	v, err := fn.Invoke()
+	if err != nil {
+		t.Fatalf("evaluating %q: %v", expr, err)
+	}
+	return safejs.Unsafe(v)
+}
+
+func assertNotPwned(t *testing.T) {
+	t.Helper()
+	if js.Global().Get("pwned").Truthy() {
+		js.Global().Delete("pwned")
+		t.Fatal("the injected code is executed")
+	}
+}
+
+func TestTemplateDataPathToJS(t *testing.T) {
+	for _, s := range adversarialStrings {
+		expr, err := templateData{Path: s}.PathToJS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := evalJS(t, expr).String()
+		assertNotPwned(t)
+		if want := validUTF8(s); got != want {
+			t.Errorf("PathToJS(%q): got %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestTemplateDataArgsToJS(t *testing.T) {
+	for _, args := range [][]string{nil, {}, adversarialStrings} {
+		expr, err := templateData{Args: args}.ArgsToJS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := evalJS(t, expr)
+		assertNotPwned(t)
+		got := []string{}
+		for i := 0; i < v.Length(); i++ {
+			got = append(got, v.Index(i).String())
+		}
+		want := []string{}
+		for _, arg := range args {
+			want = append(want, validUTF8(arg))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ArgsToJS(%q): got %q, want %q", args, got, want)
+		}
+	}
+}
+
+func TestTemplateDataEnvToJS(t *testing.T) {
+	var env []string
+	want := map[string]string{}
+	for i, s := range adversarialStrings {
+		// Both the key and value are adversarial, the key is made unique by the index.
+		k := strings.ReplaceAll(s, "=", "") + string(rune('a'+i))
+		env = append(env, k+"="+s)
+		want[validUTF8(k)] = validUTF8(s)
+	}
+	// The value can contain "=", and the last value of the duplicate keys wins, and the entries without "=" are ignored.
+	env = append(env, "DUP=first", "DUP=a=b", "NOVALUE")
+	want["DUP"] = "a=b"
+
+	expr, err := templateData{Env: env}.EnvToJS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := evalJS(t, expr)
+	assertNotPwned(t)
+	got := map[string]string{}
+	keys := js.Global().Get("Object").Call("keys", v)
+	for i := 0; i < keys.Length(); i++ {
+		k := keys.Index(i).String()
+		got[k] = v.Get(k).String()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EnvToJS(%q): got %q, want %q", env, got, want)
+	}
+}
+
+func TestBuildJS(t *testing.T) {
+	args := adversarialStrings
+	var env []string
+	for i, s := range adversarialStrings {
+		env = append(env, string(rune('a'+i))+"="+s)
+	}
+	path := `https://example.com/"); globalThis.pwned = true; ("</script>.wasm`
+	glue := "</script><script>globalThis.pwned = true</script>\n`${globalThis.pwned = true}`"
+
+	builders := map[string]func() (string, error){
+		"worker": func() (string, error) {
+			return buildWorkerJS(args, env, path, bootstrapOptions{})
+		},
+		"workerconn": func() (string, error) {
+			return buildWorkerConnJS(args, env, path, bootstrapOptions{GlueURL: path, OrderedOutput: true})
+		},
+		"sharedworker": func() (string, error) {
+			return buildSharedWorkerJS(args, env, path, bootstrapOptions{GlueJS: glue})
+		},
+		"worker-tinygo": func() (string, error) {
+			return buildWorkerJS(args, env, path, bootstrapOptions{Runtime: RuntimeTinyGo})
+		},
+		"workerconn-tinygo": func() (string, error) {
+			return buildWorkerConnJS(args, env, path, bootstrapOptions{Runtime: RuntimeTinyGo})
+		},
+		"sharedworker-tinygo": func() (string, error) {
+			return buildSharedWorkerJS(args, env, path, bootstrapOptions{Runtime: RuntimeTinyGo})
+		},
+		"workerconn-wasi": func() (string, error) {
+			return buildWorkerConnJS(args, env, path, bootstrapOptions{Runtime: RuntimeWASI})
+		},
+		"worker-module": func() (string, error) {
+			return buildWorkerJS(args, env, path, bootstrapOptions{SharedModule: true})
+		},
+		"workerconn-module": func() (string, error) {
+			return buildWorkerConnJS(args, env, path, bootstrapOptions{SharedModule: true})
+		},
+		"workerconn-wasi-module": func() (string, error) {
+			return buildWorkerConnJS(args, env, path, bootstrapOptions{Runtime: RuntimeWASI, SharedModule: true})
+		},
+	}
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			script, err := build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(script, "</script") {
+				t.Errorf("the script contains an unescaped closing script tag:\n%s", script)
+			}
+			// Only parse the script, which is not meant to run outside of a worker.
+			if _, err := safejs.Safe(js.Global().Get("Function")).New(script); err != nil {
+				t.Fatalf("parsing the script: %v\n%s", err, script)
+			}
+			assertNotPwned(t)
+		})
+	}
+}
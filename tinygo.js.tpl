@@ -0,0 +1,58 @@
+// The glue script of TinyGo goes through the WASI imports for the args/env, the stdout/stderr and the exit, instead of the properties of the Go instance.
+// Hence, wrap them to pass the go.argv and go.env, to capture the output, and to report the exit via go.exit.
+{
+    const wasi = go.importObject.wasi_snapshot_preview1;
+    const memory = () => go._inst.exports.memory.buffer;
+
+    const encoder = new TextEncoder();
+    const args = go.argv.map((arg) => encoder.encode(arg + "\0"));
+    const env = Object.entries(go.env).map(([k, v]) => encoder.encode(k + "=" + v + "\0"));
+    const sizesGet = (list) => (count_ptr, size_ptr) => {
+        const view = new DataView(memory());
+        view.setUint32(count_ptr, list.length, true);
+        view.setUint32(size_ptr, list.reduce((size, b) => size + b.length, 0), true);
+        return 0;
+    };
+    const get = (list) => (ptrs_ptr, buf_ptr) => {
+        const view = new DataView(memory());
+        for (let i = 0; i < list.length; i++) {
+            view.setUint32(ptrs_ptr + i * 4, buf_ptr, true);
+            new Uint8Array(memory(), buf_ptr, list[i].length).set(list[i]);
+            buf_ptr += list[i].length;
+        }
+        return 0;
+    };
+    wasi.args_sizes_get = sizesGet(args);
+    wasi.args_get = get(args);
+    wasi.environ_sizes_get = sizesGet(env);
+    wasi.environ_get = get(env);
+
+    // Write the output of the fd 1 and 2 via the function set by the Go program, if any, otherwise, to the console by the glue script.
+    self["{{.WriteSyncVar}}"] = null;
+    const fdWrite = wasi.fd_write;
+    wasi.fd_write = (fd, iovs_ptr, iovs_len, nwritten_ptr) => {
+        const writeSync = self["{{.WriteSyncVar}}"];
+        if (!writeSync || (fd !== 1 && fd !== 2)) {
+            return fdWrite(fd, iovs_ptr, iovs_len, nwritten_ptr);
+        }
+        let nwritten = 0;
+        for (let i = 0; i < iovs_len; i++) {
+            const view = new DataView(memory());
+            const ptr = view.getUint32(iovs_ptr + i * 8, true);
+            const len = view.getUint32(iovs_ptr + i * 8 + 4, true);
+            // Copy the data, as the memory might grow (which detaches its buffer) while the Go function handles it.
+            writeSync(fd, new Uint8Array(memory(), ptr, len).slice());
+            nwritten += len;
+        }
+        new DataView(memory()).setUint32(nwritten_ptr, nwritten, true);
+        return 0;
+    };
+
+    const procExit = wasi.proc_exit;
+    wasi.proc_exit = (code) => {
+        if (typeof go.exit === "function") {
+            go.exit(code);
+        }
+        return procExit(code);
+    };
+}
@@ -0,0 +1,195 @@
+// Wrap the payload into the envelope of the control frames, which is distinguished from the user messages.
+function controlFrame(kind, payload) {
+    return {"{{.EnvelopeMarker}}": {{.ProtocolVersion}}, kind: kind, payload: payload};
+}
+{{- if .SharedModule}}
+
+// The compiled WebAssembly.Module is sent by the controller right after this worker is created, instead of fetching and compiling the WASM.
+const modulePromise = new Promise((resolve) => {
+    const onModule = (e) => {
+        const frame = e.data;
+        if (frame && frame["{{.EnvelopeMarker}}"] === {{.ProtocolVersion}} && frame.kind === "{{.ModuleKind}}") {
+            removeEventListener("message", onModule);
+            resolve(frame.payload);
+        }
+    };
+    addEventListener("message", onModule);
+});
+{{- end}}
+
+// Report the failure of starting the WASM to the controller, then close this worker.
+function startFailed(stage, err) {
+    self.postMessage(controlFrame("{{.StartErrorKind}}", JSON.stringify({stage: stage, message: String(err)})));
+    self.close();
+}
+
+const args = {{.ArgsToJS}};
+const env = {{.EnvToJS}};
+
+// Report the exit code of the WASI module to the controller, then close this worker.
+let exited = false;
+function exit(code) {
+    if (exited) {
+        return;
+    }
+    exited = true;
+    self.postMessage(controlFrame("{{.ExitKind}}", code));
+    self.close();
+}
+
+// Report the crash of the WASI module without exiting, e.g. a WebAssembly trap, to the controller, then close this worker.
+function crash(err) {
+    if (exited) {
+        return;
+    }
+    exited = true;
+    self.postMessage(controlFrame("{{.CrashKind}}", String(err)));
+    self.close();
+}
+
+// The WASI errno values.
+const ERRNO_SUCCESS = 0;
+const ERRNO_BADF = 8;
+const ERRNO_NOSYS = 52;
+const ERRNO_SPIPE = 70;
+
+// exitSignal is thrown by proc_exit to unwind the WASI module.
+const exitSignal = new Error("proc_exit");
+
+let instance;
+const memory = () => instance.exports.memory.buffer;
+
+const encoder = new TextEncoder();
+const argBufs = args.map((arg) => encoder.encode(arg + "\0"));
+const envBufs = Object.entries(env).map(([k, v]) => encoder.encode(k + "=" + v + "\0"));
+const sizesGet = (list) => (count_ptr, size_ptr) => {
+    const view = new DataView(memory());
+    view.setUint32(count_ptr, list.length, true);
+    view.setUint32(size_ptr, list.reduce((size, b) => size + b.length, 0), true);
+    return ERRNO_SUCCESS;
+};
+const get = (list) => (ptrs_ptr, buf_ptr) => {
+    const view = new DataView(memory());
+    for (let i = 0; i < list.length; i++) {
+        view.setUint32(ptrs_ptr + i * 4, buf_ptr, true);
+        new Uint8Array(memory(), buf_ptr, list[i].length).set(list[i]);
+        buf_ptr += list[i].length;
+    }
+    return ERRNO_SUCCESS;
+};
+
+// The WASI functions for a module that only deals with the args, env, stdio, clocks and random numbers. There is no file system.
+const wasi = {
+    args_sizes_get: sizesGet(argBufs),
+    args_get: get(argBufs),
+    environ_sizes_get: sizesGet(envBufs),
+    environ_get: get(envBufs),
+
+    // The output of the fd 1 and 2 is sent to the controller, as the stdout and stderr frames.
+    fd_write: (fd, iovs_ptr, iovs_len, nwritten_ptr) => {
+        if (fd !== 1 && fd !== 2) {
+            return ERRNO_BADF;
+        }
+        let nwritten = 0;
+        for (let i = 0; i < iovs_len; i++) {
+            const view = new DataView(memory());
+            const ptr = view.getUint32(iovs_ptr + i * 8, true);
+            const len = view.getUint32(iovs_ptr + i * 8 + 4, true);
+            const data = new Uint8Array(memory(), ptr, len).slice();
+            self.postMessage(controlFrame(fd === 2 ? "{{.StderrKind}}" : "{{.StdoutKind}}", data), [data.buffer]);
+            nwritten += len;
+        }
+        new DataView(memory()).setUint32(nwritten_ptr, nwritten, true);
+        return ERRNO_SUCCESS;
+    },
+    // The module runs synchronously, hence it can't wait for the stdin sent from the controller. Reading from the fd 0 always hits EOF.
+    fd_read: (fd, iovs_ptr, iovs_len, nread_ptr) => {
+        if (fd !== 0) {
+            return ERRNO_BADF;
+        }
+        new DataView(memory()).setUint32(nread_ptr, 0, true);
+        return ERRNO_SUCCESS;
+    },
+    fd_fdstat_get: (fd, stat_ptr) => {
+        if (fd > 2) {
+            return ERRNO_BADF;
+        }
+        // The stdio are character devices, without any flags or rights.
+        new Uint8Array(memory(), stat_ptr, 24).fill(0);
+        new DataView(memory()).setUint8(stat_ptr, 2);
+        return ERRNO_SUCCESS;
+    },
+    fd_prestat_get: () => ERRNO_BADF,
+    fd_close: (fd) => (fd > 2 ? ERRNO_BADF : ERRNO_SUCCESS),
+    fd_seek: (fd) => (fd > 2 ? ERRNO_BADF : ERRNO_SPIPE),
+    clock_res_get: (id, res_ptr) => {
+        new DataView(memory()).setBigUint64(res_ptr, 1000n, true);
+        return ERRNO_SUCCESS;
+    },
+    clock_time_get: (id, precision, time_ptr) => {
+        // The realtime clock is of id 0, the others are treated as the monotonic clock.
+        const ns = id === 0 ? BigInt(Date.now()) * 1000000n : BigInt(Math.round((performance.timeOrigin + performance.now()) * 1e6));
+        new DataView(memory()).setBigUint64(time_ptr, ns, true);
+        return ERRNO_SUCCESS;
+    },
+    random_get: (buf_ptr, buf_len) => {
+        // getRandomValues fills at most 65536 bytes at a time.
+        for (let offset = 0; offset < buf_len; offset += 65536) {
+            crypto.getRandomValues(new Uint8Array(memory(), buf_ptr + offset, Math.min(65536, buf_len - offset)));
+        }
+        return ERRNO_SUCCESS;
+    },
+    sched_yield: () => ERRNO_SUCCESS,
+    proc_exit: (code) => {
+        exit(code);
+        throw exitSignal;
+    },
+};
+
+// Any other WASI function is not supported.
+const wasiImport = new Proxy(wasi, {
+    get: (target, name) => target[name] || (() => ERRNO_NOSYS),
+});
+const importObject = {wasi_snapshot_preview1: wasiImport, wasi_unstable: wasiImport};
+
+(async () => {
+    let resp, module;
+{{- if .SharedModule}}
+    module = await modulePromise;
+{{- else}}
+    try {
+        resp = await fetch({{.PathToJS}});
+        if (!resp.ok) {
+            throw new Error(`${resp.status} ${resp.statusText}`);
+        }
+    } catch (err) {
+        return startFailed("fetch", err);
+    }
+    try {
+        module = await WebAssembly.compileStreaming(resp);
+    } catch (err) {
+        return startFailed("compile", err);
+    }
+{{- end}}
+    try {
+        instance = await WebAssembly.instantiate(module, importObject);
+        if (typeof instance.exports._start !== "function") {
+            throw new Error("the WASI module has no _start export");
+        }
+    } catch (err) {
+        return startFailed("link", err);
+    }
+
+    // Notify the controller that this worker is ready, which is done by the SelfConn for a Go program.
+    self.postMessage(null);
+
+    try {
+        instance.exports._start();
+    } catch (err) {
+        if (err !== exitSignal) {
+            return crash(err);
+        }
+    }
+    // Returning from the _start means exiting with status 0.
+    exit(0);
+})();
@@ -41,6 +41,22 @@ type WasmSharedWebWorker struct {
 	// This is ignored in the Connect().
 	Env []string
 
+	// GlueURL is the URL of the Go glue script (i.e. wasm_exec.js) imported by the worker, e.g. when the app is served under a sub-path, or from a CDN.
+	// This can be a relative path on the server, or an absolute URL. If GlueURL is empty, the "/wasm_exec.js" of the current origin is used.
+	//
+	// This is ignored in the Connect().
+	GlueURL string
+
+	// GlueJS, if non-empty, is the content of the Go glue script, which is inlined into the worker instead of being imported from the GlueURL.
+	//
+	// This is ignored in the Connect().
+	GlueJS string
+
+	// Runtime is the toolchain that the WASM is built with, which defaults to RuntimeGo.
+	//
+	// This is ignored in the Connect().
+	Runtime Runtime
+
 	// url represents the web worker script url.
 	// This is filled in in the Start(), and is required in the Connect().
 	URL string
@@ -49,7 +65,7 @@ type WasmSharedWebWorker struct {
 }
 
 func (ww *WasmSharedWebWorker) Start() error {
-	workerJS, err := buildWorkerJS(ww.Args, ww.Env, ww.Path)
+	workerJS, err := buildWorkerJS(ww.Args, ww.Env, ww.Path, ww.bootstrapOptions())
 	if err != nil {
 		return err
 	}
@@ -69,8 +85,16 @@ func (ww *WasmSharedWebWorker) Start() error {
 	return nil
 }
 
+func (ww *WasmSharedWebWorker) bootstrapOptions() bootstrapOptions {
+	return bootstrapOptions{
+		GlueURL: ww.GlueURL,
+		GlueJS:  ww.GlueJS,
+		Runtime: ww.Runtime,
+	}
+}
+
 func (ww *WasmSharedWebWorker) startForConn() error {
-	workerJS, err := buildSharedWorkerJS(ww.Args, ww.Env, ww.Path)
+	workerJS, err := buildSharedWorkerJS(ww.Args, ww.Env, ww.Path, ww.bootstrapOptions())
 	if err != nil {
 		return err
 	}
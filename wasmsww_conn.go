@@ -4,9 +4,10 @@ package wasmww
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log/slog"
+	"net/http"
 	"sync"
-	"syscall/js"
 
 	"github.com/hack-pad/safejs"
 	"github.com/magodo/go-webworkers/types"
@@ -36,14 +37,41 @@ type WasmSharedWebWorkerConn struct {
 	// value in the slice for each duplicate key is used.
 	Env []string
 
+	// GlueURL is the URL of the Go glue script (i.e. wasm_exec.js) imported by the worker, e.g. when the app is served under a sub-path, or from a CDN.
+	// This can be a relative path on the server, or an absolute URL. If GlueURL is empty, the "/wasm_exec.js" of the current origin is used.
+	GlueURL string
+
+	// GlueJS, if non-empty, is the content of the Go glue script, which is inlined into the worker instead of being imported from the GlueURL.
+	GlueJS string
+
+	// Runtime is the toolchain that the WASM is built with, which defaults to RuntimeGo.
+	Runtime Runtime
+
 	// URL represents the web worker script URL.
 	// This is populated in the Start().
 	URL string
 
-	ww        *WasmSharedWebWorker
-	closeFunc WebWorkerCloseFunc
-	eventCh   chan types.MessageEventMessage
-	closeCh   chan any
+	// Codec is used to encode and decode the values for Send and Receive.
+	// If Codec is nil, JSONCodec is used.
+	Codec Codec
+
+	// LogHandler handles the log records sent by the slog.Handler returned by SelfSharedConn.LogHandler in the worker,
+	// with the worker name added as the "worker" attribute. If LogHandler is nil, slog.Default() is used.
+	// It is only used by Start, which passes it to the returned WasmSharedWebWorkerMgmtConn.
+	LogHandler slog.Handler
+
+	// ErrorHandler, if non-nil, is called with the errors encountered by the returned WasmSharedWebWorkerMgmtConn while relaying the events of the worker,
+	// e.g. failing to write to its Stdout or Stderr, see WasmSharedWebWorkerMgmtConn.Err.
+	// It is only used by Start, which passes it to the returned WasmSharedWebWorkerMgmtConn.
+	ErrorHandler func(error)
+
+	ww          *WasmSharedWebWorker
+	rpc         *rpcClient
+	http        *httpClient
+	closeReason *CloseReason
+	closeFunc   WebWorkerCloseFunc
+	eventCh     chan types.MessageEventMessage
+	closeCh     chan any
 }
 
 // Start starts a new Shared Web Worker. It spins up a goroutine to receive the events from the Web Worker,
@@ -51,16 +79,29 @@ type WasmSharedWebWorkerConn struct {
 // It will fail if the Shared Web Worker already exists. In this case, use Connect() instead.
 // The returned WasmSharedWebWorkerMgmtConn is a special connection, that is used to manage the web worker, or
 // create another WasmSharedWebWorkerConn to this web worker via its Connect() method.
+//
+// Start blocks until the worker sets up the connection. If the worker fails to start the WASM, a *StartError is returned.
 func (conn *WasmSharedWebWorkerConn) Start() (*WasmSharedWebWorkerMgmtConn, error) {
+	return conn.StartContext(context.Background())
+}
+
+// StartContext is like Start, but aborts waiting for the worker to set up the connection once the ctx is done.
+// In which case, the ctx error is returned.
+func (conn *WasmSharedWebWorkerConn) StartContext(ctx context.Context) (*WasmSharedWebWorkerMgmtConn, error) {
 	// The first connection to the web worker is for the stdout/stderr
 	mgmtConn := &WasmSharedWebWorkerMgmtConn{
-		name: conn.Name,
-		path: conn.Path,
-		args: conn.Args,
-		env:  conn.Env,
+		name:       conn.Name,
+		path:       conn.Path,
+		args:       conn.Args,
+		env:        conn.Env,
+		glueURL:    conn.GlueURL,
+		glueJS:     conn.GlueJS,
+		runtime:    conn.Runtime,
+		logHandler: conn.LogHandler,
+		relayErr:   newRelayError(conn.ErrorHandler),
 	}
 
-	if err := mgmtConn.start(); err != nil {
+	if err := mgmtConn.start(ctx); err != nil {
 		return nil, err
 	}
 	if conn.Name == "" {
@@ -68,17 +109,21 @@ func (conn *WasmSharedWebWorkerConn) Start() (*WasmSharedWebWorkerMgmtConn, erro
 	}
 	conn.URL = mgmtConn.url
 
-	newConn, err := mgmtConn.Connect()
-	if err != nil {
+	// Connect in place, as the relay goroutine refers to the conn, e.g. to record its close reason.
+	if err := conn.ConnectContext(ctx); err != nil {
 		return nil, err
 	}
-	*conn = *newConn
 	return mgmtConn, nil
 }
 
 // Connect creates a new WasmSharedWebWorkerConn to an active Shared Web Worker.
 // Only the conn.Name and conn.URL matters.
-func (conn *WasmSharedWebWorkerConn) Connect() (err error) {
+func (conn *WasmSharedWebWorkerConn) Connect() error {
+	return conn.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect, but aborts waiting for the worker to set up the connection once the ctx is done.
+func (conn *WasmSharedWebWorkerConn) ConnectContext(connectCtx context.Context) (err error) {
 	ww := &WasmSharedWebWorker{
 		Name: conn.Name,
 		URL:  conn.URL,
@@ -89,6 +134,7 @@ func (conn *WasmSharedWebWorkerConn) Connect() (err error) {
 	}
 
 	conn.ww = ww
+	conn.closeReason = nil
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
@@ -103,50 +149,92 @@ func (conn *WasmSharedWebWorkerConn) Connect() (err error) {
 	}
 
 	// Wait for the sync message
-	if _, ok := <-rawCh; !ok {
-		return fmt.Errorf("channel closed (due to ctx canceled)")
+	if _, err := waitSyncEvent(connectCtx, rawCh); err != nil {
+		ww.Close()
+		return err
 	}
 
 	// Create a channel to relay the event from the onmessage channel to the consuming channel,
 	// except it will cancel the listening context and close the channel when the worker closes.
-	eventCh := make(chan types.MessageEventMessage)
+	// The events are queued, so that the control frames, e.g. the RPC, HTTP and close frames, are handled even if the consuming channel isn't drained.
+	queue := newEventQueue()
+	eventCh := queue.ch
 	closeCh := make(chan any)
+	rpc := newRPCClient()
+	httpc := newHTTPClient()
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		var reason *CloseReason
 		for event := range rawCh {
-			if data, err := event.Data(); err == nil {
-				if str, err := data.String(); err == nil {
-					if str == CLOSE_EVENT {
-						cancel()
-						continue
-					}
+			frame, ok := eventControlFrame(event)
+			if !ok {
+				queue.push(event)
+				continue
+			}
+			switch frame.Kind {
+			case frameClose:
+				msg := parseCloseFrame(frame)
+				reason = &CloseReason{Kind: ClosePortClosed, Code: msg.Code, Reason: msg.Reason}
+				if msg.Worker {
+					reason.Kind = CloseWorkerClosed
+				}
+				cancel()
+			// The exit and crash frames mean the Go program in the worker is gone, which closes all the connections.
+			case frameExit, frameCrash:
+				if state, ok := parseExitFrame(frame); ok {
+					reason = state.CloseReason()
 				}
+				cancel()
+			case frameRPCResponse:
+				rpc.dispatch(frame)
+			case frameHTTP:
+				httpc.dispatch(frame)
+			case frameNetConnEOF:
+				queue.push(event)
 			}
-			eventCh <- event
 		}
+		// The relay only stops without a reason when the controller closes the connection.
+		if reason == nil {
+			reason = &CloseReason{Kind: CloseTerminated}
+		}
+		conn.closeReason = reason
+		rpc.close()
+		httpc.close()
 		close(closeCh)
-		close(eventCh)
+		queue.close()
 		conn.ww = nil
 	}()
 	conn.closeFunc = func() error {
 		cancel()
 		wg.Wait()
-		if err := ww.PostMessage(safejs.Safe(js.ValueOf(CLOSE_EVENT)), nil); err != nil {
+		if err := postControlFrame(ww, frameClose, nil, nil); err != nil {
 			return err
 		}
 		return ww.Close()
 	}
 	conn.eventCh = eventCh
 	conn.closeCh = closeCh
+	conn.rpc = rpc
+	conn.http = httpc
 
 	return nil
 }
 
 // Wait waits for the controller's internal event loop to quit. This can be caused by the worker closes itself.
-func (conn *WasmSharedWebWorkerConn) Wait() {
+// It always returns nil, which is to satisfy the Conn interface.
+func (conn *WasmSharedWebWorkerConn) Wait() error {
 	<-conn.closeCh
+	return nil
+}
+
+// CloseReason returns why the connection is closed, available once the EventChannel is closed, or after a call to Wait.
+// It tells whether the worker closed the port (with the code and reason passed to SelfSharedConnPort.CloseWithReason),
+// the worker closed itself, the connection is closed by the controller, or the worker exited or crashed.
+// It returns nil if the connection is not closed yet.
+func (conn *WasmSharedWebWorkerConn) CloseReason() *CloseReason {
+	return conn.closeReason
 }
 
 // PostMessage sends data in a message to the worker, optionally transferring ownership of all items in transfers.
@@ -159,6 +247,45 @@ func (conn *WasmSharedWebWorkerConn) EventChannel() <-chan types.MessageEventMes
 	return conn.eventCh
 }
 
+// Send encodes v with the Codec, and sends it to the worker.
+func (conn *WasmSharedWebWorkerConn) Send(v any) error {
+	return send(conn.ww, conn.Codec, v)
+}
+
+// Receive receives the next event sent from the worker, and decodes it with the Codec into v.
+// It returns io.EOF once this connection is closed.
+//
+// Receive consumes the EventChannel(), hence it shall not be used together with other consumers of the channel.
+func (conn *WasmSharedWebWorkerConn) Receive(v any) error {
+	return receive(conn.eventCh, conn.Codec, v)
+}
+
+// Call calls the method registered in the worker via SelfSharedConnPort.Handle, and waits for the reply.
+// The args is JSON encoded and sent to the worker, and the returned reply is JSON decoded into the reply, if not nil.
+//
+// Multiple calls can be in-flight concurrently. Once the ctx is done, Call returns the ctx error, and the worker is notified to cancel the handling.
+// If the handler returns an error, it is returned as an *RPCError.
+func (conn *WasmSharedWebWorkerConn) Call(ctx context.Context, method string, args any, reply any) error {
+	if conn.rpc == nil {
+		return errors.New("wasmww: Call before connected")
+	}
+	return conn.rpc.call(ctx, conn.ww, method, args, reply)
+}
+
+// Transport returns an http.RoundTripper that sends the requests to the handler served in the worker via SelfSharedConnPort.ServeHTTP.
+// The request and response bodies are streamed in chunks, and the in-flight requests are canceled once the request ctx is done,
+// or the response body is closed before EOF.
+func (conn *WasmSharedWebWorkerConn) Transport() http.RoundTripper {
+	return &httpTransport{
+		client: func() (*httpClient, MessagePoster) {
+			if conn.http == nil {
+				return nil, nil
+			}
+			return conn.http, conn.ww
+		},
+	}
+}
+
 // Close closes this WasmSharedWebWorkerConn at the outside and notify the web worker.
 func (conn *WasmSharedWebWorkerConn) Close() error {
 	return conn.closeFunc()
@@ -0,0 +1,59 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"testing"
+)
+
+func TestWasmSharedWebWorkerConnCloseReason(t *testing.T) {
+	workers := installJSWorkers(t)
+	cases := map[string]struct {
+		// close closes the connection, from the port of the worker, or the controller conn.
+		close func(t *testing.T, port *jsWorker, conn *WasmSharedWebWorkerConn)
+		want  CloseReason
+	}{
+		"port closed": {
+			close: func(t *testing.T, port *jsWorker, conn *WasmSharedWebWorkerConn) {
+				if err := postCloseFrame(port, closeMessage{Code: 1, Reason: "done"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CloseReason{Kind: ClosePortClosed, Code: 1, Reason: "done"},
+		},
+		"worker closed": {
+			close: func(t *testing.T, port *jsWorker, conn *WasmSharedWebWorkerConn) {
+				if err := postCloseFrame(port, closeMessage{Code: 2, Reason: "bye", Worker: true}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CloseReason{Kind: CloseWorkerClosed, Code: 2, Reason: "bye"},
+		},
+		"exited": {
+			close: func(t *testing.T, port *jsWorker, conn *WasmSharedWebWorkerConn) {
+				port.postFrame(frameExit, 1)
+			},
+			want: CloseReason{Kind: CloseExited, Code: 1},
+		},
+		"closed by controller": {
+			close: func(t *testing.T, port *jsWorker, conn *WasmSharedWebWorkerConn) {
+				if err := conn.Close(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CloseReason{Kind: CloseTerminated},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			// The conn is connected in place by the Start, whose relay records the close reason.
+			conn := &WasmSharedWebWorkerConn{}
+			_, _, port := startJSSharedConn(t, workers, conn)
+			c.close(t, port, conn)
+			waitTimeout(t, conn.Wait)
+			if reason := conn.CloseReason(); reason == nil || *reason != c.want {
+				t.Errorf("got the close reason %v, want %v", reason, &c.want)
+			}
+		})
+	}
+}
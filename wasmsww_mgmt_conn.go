@@ -4,25 +4,30 @@ package wasmww
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"strings"
+	"log/slog"
 	"sync"
-	"syscall/js"
-
-	"github.com/hack-pad/safejs"
+	"sync/atomic"
+	"time"
 
 	"github.com/magodo/chanio"
 )
 
+// mgmtCloseTimeout is how long the Close of the WasmSharedWebWorkerMgmtConn waits for the worker to flush its output and reply the close frame,
+// before it stops relaying the events regardless.
+var mgmtCloseTimeout = 5 * time.Second
+
 // WasmSharedWebWorkerMgmtConn is a connection to a newly started Shared Web Worker.
 // It is only meant to:
-// - Receive stdout/stderr from the worker, in form of the message event.
+// - Receive stdout/stderr and log records from the worker, in form of the message event.
 // - Send mgmt message events to the worker, including:
 //   - Close event to let it close itself
 //   - SetWriteToConsole event to let it write to console
 //   - SetWriteToController event to let it write to this port back to the controller
+//   - SetWriteToDiscard event to let it discard the output
+//   - SetWriteToTee event to let it write to both the console and the controller
 type WasmSharedWebWorkerMgmtConn struct {
 	name string
 	path string
@@ -30,20 +35,36 @@ type WasmSharedWebWorkerMgmtConn struct {
 	env  []string
 	url  string
 
+	glueURL string
+	glueJS  string
+	runtime Runtime
+
 	stdout io.ReadCloser
 	stderr io.ReadCloser
 
+	processState *ProcessState
+
+	// logMu guards the logHandler.
+	logMu      sync.Mutex
+	logHandler slog.Handler
+
+	relayErr *relayError
+
 	ww        *WasmSharedWebWorker
 	closeFunc WebWorkerCloseFunc
 	closeCh   chan any
 }
 
-func (c *WasmSharedWebWorkerMgmtConn) start() (err error) {
+func (c *WasmSharedWebWorkerMgmtConn) start(startCtx context.Context) (err error) {
 	ww := &WasmSharedWebWorker{
 		Name: c.name,
 		Path: c.path,
 		Args: c.args,
 		Env:  c.env,
+
+		GlueURL: c.glueURL,
+		GlueJS:  c.glueJS,
+		Runtime: c.runtime,
 	}
 	if err := ww.startForConn(); err != nil {
 		return err
@@ -67,8 +88,9 @@ func (c *WasmSharedWebWorkerMgmtConn) start() (err error) {
 	}
 
 	// Wait for the worker's initial sync event, which indicates the worker is ready to receive connect events.
-	if _, ok := <-initCh; !ok {
-		return fmt.Errorf("message event channel closed (due to ctx canceled)")
+	if _, err := waitSyncEvent(startCtx, initCh); err != nil {
+		ww.Close()
+		return err
 	}
 
 	// No need to listen for the initial channel, so we close it and the underlying resources.
@@ -113,10 +135,10 @@ func (c *WasmSharedWebWorkerMgmtConn) start() (err error) {
 	}
 
 	// Wait for the worker's console msg ready event, which is non-null only to indicate the console message channel is ready.
-	readyMsg, ok := <-mgmtCh
-	if !ok {
-		err = fmt.Errorf("message event channel closed (due to ctx canceled)")
-		return
+	readyMsg, err := waitSyncEvent(startCtx, mgmtCh)
+	if err != nil {
+		ww.Close()
+		return err
 	}
 	data, err := readyMsg.Data()
 	if err != nil {
@@ -129,47 +151,91 @@ func (c *WasmSharedWebWorkerMgmtConn) start() (err error) {
 
 	// Consume the message that represents the stdout/stderr of the web worker.
 	// It will cancel the listening context and close the channel when the worker closes.
+	// Once writing to the stdout or stderr fails, e.g. the reader is closed, the subsequent output of that stream is dropped.
+	var broken [3]bool
+	writeOutput := func(fd int, b []byte) {
+		w, name := stdoutW, "stdout"
+		if fd == 2 {
+			w, name = stderrW, "stderr"
+		}
+		if broken[fd] {
+			return
+		}
+		if _, err := w.Write(b); err != nil {
+			broken[fd] = true
+			c.relayErr.report(fmt.Errorf("writing to %s: %w", name, err))
+		}
+	}
+	// closeRequested tells whether the close frame is posted to the worker by the closeFunc.
+	var closeRequested atomic.Bool
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		var state *ProcessState
 		for event := range mgmtCh {
-			if data, err := event.Data(); err == nil {
-				if str, err := data.String(); err == nil {
-					if str == CLOSE_EVENT {
-						cancel()
-						stdoutW.Close()
-						stderrW.Close()
-						continue
-					}
-					if strings.HasPrefix(str, STDOUT_EVENT) {
-						if _, err := stdoutW.Write([]byte(str[len(STDOUT_EVENT):])); err != nil {
-							log.Fatalf("Controller writing to stdout: %v", err)
-						}
-						continue
-					}
-					if strings.HasPrefix(str, STDERR_EVENT) {
-						if _, err := stderrW.Write([]byte(str[len(STDERR_EVENT):])); err != nil {
-							log.Fatalf("Controller writing to stderr: %v", err)
-						}
-						continue
-					}
-					log.Fatalf("Only expected {STDOUT|STDERR|CLOSE}_EVENT, got=%q", str)
+			frame, ok := eventControlFrame(event)
+			if !ok {
+				c.relayErr.report(errors.New("unexpected non-control message on the mgmt port"))
+				continue
+			}
+			switch frame.Kind {
+			case frameClose:
+				state = newClosedState(parseCloseFrame(frame))
+				cancel()
+			case frameExit, frameCrash:
+				if exitState, ok := parseExitFrame(frame); ok {
+					state = exitState
+					cancel()
+				}
+			case frameLog:
+				c.logMu.Lock()
+				handler := c.logHandler
+				c.logMu.Unlock()
+				handleLogFrame(handler, frame)
+			case frameConsole:
+				if fd, line, ok := consoleOutput(frame); ok {
+					writeOutput(fd, line)
+				}
+			case frameStdout:
+				if b, err := bytesFromJS(frame.Payload); err == nil {
+					writeOutput(1, b)
 				}
+			case frameStderr:
+				if b, err := bytesFromJS(frame.Payload); err == nil {
+					writeOutput(2, b)
+				}
+			}
+		}
+		// The relay only stops without a state when the controller closes the worker. A graceful close, where the worker is told to
+		// close itself, is recorded as such, otherwise the worker isn't known to be closed.
+		if state == nil {
+			if closeRequested.Load() {
+				state = newClosedState(closeMessage{})
+			} else {
+				state = newTerminatedState()
 			}
 		}
+		c.processState = state
+		stdoutW.Close()
+		stderrW.Close()
 		close(closeCh)
 	}()
 
 	c.closeFunc = func() error {
+		// Tell the worker to close itself, and wait for its close frame, which comes after its flushed output, before stopping the relay.
+		// If the worker doesn't reply in time, the close is still recorded as graceful.
+		err := postControlFrame(ww, frameClose, nil, nil)
+		if err == nil {
+			closeRequested.Store(true)
+			select {
+			case <-closeCh:
+			case <-time.After(mgmtCloseTimeout):
+			}
+		}
 		cancel()
 		wg.Wait()
-		if err := ww.PostMessage(safejs.Safe(js.ValueOf(CLOSE_EVENT)), nil); err != nil {
-			return err
-		}
-		stdoutW.Close()
-		stderrW.Close()
-		return nil
+		return err
 	}
 
 	return nil
@@ -177,6 +243,11 @@ func (c *WasmSharedWebWorkerMgmtConn) start() (err error) {
 
 // Connect is a utility function taht creates a new WasmSharedWebWorkerConn and connect it to the active Shared Web Worker.
 func (c *WasmSharedWebWorkerMgmtConn) Connect() (conn *WasmSharedWebWorkerConn, err error) {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect, but aborts waiting for the worker to set up the connection once the ctx is done.
+func (c *WasmSharedWebWorkerMgmtConn) ConnectContext(ctx context.Context) (conn *WasmSharedWebWorkerConn, err error) {
 	conn = &WasmSharedWebWorkerConn{
 		Name: c.name,
 		Path: c.path,
@@ -184,7 +255,7 @@ func (c *WasmSharedWebWorkerMgmtConn) Connect() (conn *WasmSharedWebWorkerConn,
 		Env:  c.env,
 		URL:  c.url,
 	}
-	if err := conn.Connect(); err != nil {
+	if err := conn.ConnectContext(ctx); err != nil {
 		return nil, err
 	}
 	return conn, nil
@@ -192,24 +263,67 @@ func (c *WasmSharedWebWorkerMgmtConn) Connect() (conn *WasmSharedWebWorkerConn,
 
 // Close mimics the terminate method of the DedicatedWorkerGlobalScope, but more gracefully.
 // It sends a close message to the shared worker, which will in turn relay the close message back to the outside, and close itself in the meanwhile.
+// It waits for the worker to flush its output and reply the close message, before it stops relaying the events, so that the tail of the output isn't lost.
 func (c *WasmSharedWebWorkerMgmtConn) Close() error {
 	return c.closeFunc()
 }
 
 // SetWriteToConsole instructs the worker to write its stdout/stderr to console
 func (c *WasmSharedWebWorkerMgmtConn) SetWriteToConsole() error {
-	return c.ww.PostMessage(safejs.Safe(js.ValueOf(WRITE_TO_CONSOLE_EVENT)), nil)
+	return postControlFrame(c.ww, frameWriteToConsole, nil, nil)
 }
 
 // SetWriteToController instructs the worker to write its stdout/stderr to controller, which can be retrieved by Stdout(), Stderr().
 func (c *WasmSharedWebWorkerMgmtConn) SetWriteToController() error {
-	return c.ww.PostMessage(safejs.Safe(js.ValueOf(WRITE_TO_CONTROLLER_EVENT)), nil)
+	return postControlFrame(c.ww, frameWriteToController, nil, nil)
+}
+
+// SetWriteToDiscard instructs the worker to discard its stdout/stderr.
+func (c *WasmSharedWebWorkerMgmtConn) SetWriteToDiscard() error {
+	return postControlFrame(c.ww, frameWriteToDiscard, nil, nil)
+}
 
+// SetWriteToTee instructs the worker to write its stdout/stderr to both the controller and the console.
+func (c *WasmSharedWebWorkerMgmtConn) SetWriteToTee() error {
+	return postControlFrame(c.ww, frameWriteToTee, nil, nil)
+}
+
+// SetLogHandler sets the handler of the log records sent by the slog.Handler returned by SelfSharedConn.LogHandler in the worker,
+// with the worker name added as the "worker" attribute. If handler is nil, slog.Default() is used.
+// It defaults to the LogHandler of the WasmSharedWebWorkerConn that starts the worker.
+func (c *WasmSharedWebWorkerMgmtConn) SetLogHandler(handler slog.Handler) {
+	c.logMu.Lock()
+	defer c.logMu.Unlock()
+	c.logHandler = handler
 }
 
 // Wait waits for the controller's internal event loop to quit. This can be caused by the worker closes itself.
-func (c *WasmSharedWebWorkerMgmtConn) Wait() {
+//
+// The returned error is nil if the Go program in the worker exits with status 0, or the worker closes itself, including via Close,
+// and no error is encountered while relaying the events (see Err).
+// Otherwise, e.g. the Go program exits with a non-zero status or panics, the error is of type *ExitError.
+func (c *WasmSharedWebWorkerMgmtConn) Wait() error {
 	<-c.closeCh
+	if err := waitError(c.processState); err != nil {
+		return err
+	}
+	return c.Err()
+}
+
+// Err returns the first error encountered while relaying the events of the worker, e.g. failing to write to the Stdout or Stderr,
+// or receiving an unexpected message. It returns nil if there is no such error.
+func (c *WasmSharedWebWorkerMgmtConn) Err() error {
+	return c.relayErr.err()
+}
+
+// ProcessState contains information about the exited worker, available after a call to Wait.
+func (c *WasmSharedWebWorkerMgmtConn) ProcessState() *ProcessState {
+	return c.processState
+}
+
+// CloseReason returns why the worker is closed, available after a call to Wait.
+func (c *WasmSharedWebWorkerMgmtConn) CloseReason() *CloseReason {
+	return c.processState.CloseReason()
 }
 
 // Stdout returns an io.ReadCloser that streams out the stdout of the web worker as long as its target write destination is not modified to redirect to other sinks
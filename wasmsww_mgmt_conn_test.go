@@ -0,0 +1,119 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// startJSSharedConn starts the conn with the fake SharedWorker, and returns the fake workers of its mgmt port and its own port.
+func startJSSharedConn(t *testing.T, workers *jsWorkers, conn *WasmSharedWebWorkerConn) (*WasmSharedWebWorkerMgmtConn, *jsWorker, *jsWorker) {
+	t.Helper()
+	if conn.Path == "" {
+		conn.Path = testWASMPath
+	}
+	type result struct {
+		mgmtConn *WasmSharedWebWorkerMgmtConn
+		err      error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		mgmtConn, err := conn.Start()
+		resultCh <- result{mgmtConn, err}
+	}()
+	// The initial sync event, which indicates the worker is ready to receive connect events.
+	workers.next().post(nil)
+	// The non-null event on the mgmt port, which indicates the mgmt port is set up.
+	mgmt := workers.next()
+	mgmt.post(true)
+	// The sync event of the port of the conn.
+	port := workers.next()
+	port.post(nil)
+	r := <-resultCh
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	return r.mgmtConn, mgmt, port
+}
+
+func TestWasmSharedWebWorkerMgmtConnClose(t *testing.T) {
+	origin := mgmtCloseTimeout
+	mgmtCloseTimeout = 100 * time.Millisecond
+	t.Cleanup(func() {
+		mgmtCloseTimeout = origin
+	})
+
+	cases := map[string]struct {
+		// reply tells whether the worker flushes its output and replies the close frame.
+		reply      bool
+		wantStdout string
+	}{
+		"reply": {
+			reply:      true,
+			wantStdout: "tail",
+		},
+		"no reply": {},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			workers := installJSWorkers(t)
+			mgmtConn, mgmt, _ := startJSSharedConn(t, workers, &WasmSharedWebWorkerConn{})
+
+			stdoutCh := make(chan string, 1)
+			go func() {
+				b, _ := io.ReadAll(mgmtConn.Stdout())
+				stdoutCh <- string(b)
+			}()
+			go func() {
+				mgmt.nextFrame(frameClose)
+				if !c.reply {
+					return
+				}
+				arr, transfers, err := bytesToTransferable([]byte("tail"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := postControlFrame(mgmt, frameStdout, arr, transfers); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := postCloseFrame(mgmt, closeMessage{}); err != nil {
+					t.Error(err)
+				}
+			}()
+
+			if err := waitTimeout(t, mgmtConn.Close); err != nil {
+				t.Fatal(err)
+			}
+			if err := waitTimeout(t, mgmtConn.Wait); err != nil {
+				t.Fatalf("Wait: %v", err)
+			}
+			if got := <-stdoutCh; got != c.wantStdout {
+				t.Errorf("got stdout %q, want %q", got, c.wantStdout)
+			}
+			if reason := mgmtConn.CloseReason(); reason == nil || reason.Kind != CloseWorkerClosed {
+				t.Errorf("got the close reason %v, want the worker closed", reason)
+			}
+		})
+	}
+}
+
+func TestWasmSharedWebWorkerMgmtConnCloseReason(t *testing.T) {
+	workers := installJSWorkers(t)
+	mgmtConn, mgmt, _ := startJSSharedConn(t, workers, &WasmSharedWebWorkerConn{})
+
+	// The worker closes itself via SelfSharedConn.CloseWithReason.
+	if err := postCloseFrame(mgmt, closeMessage{Code: 5, Reason: "shutdown"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitTimeout(t, mgmtConn.Wait); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	want := CloseReason{Kind: CloseWorkerClosed, Code: 5, Reason: "shutdown"}
+	if reason := mgmtConn.CloseReason(); reason == nil || *reason != want {
+		t.Errorf("got the close reason %v, want %v", reason, &want)
+	}
+}
@@ -32,15 +32,51 @@ type WasmWebWorker struct {
 	// value in the slice for each duplicate key is used.
 	Env []string
 
+	// GlueURL is the URL of the Go glue script (i.e. wasm_exec.js) imported by the worker, e.g. when the app is served under a sub-path, or from a CDN.
+	// This can be a relative path on the server, or an absolute URL. If GlueURL is empty, the "/wasm_exec.js" of the current origin is used.
+	GlueURL string
+
+	// GlueJS, if non-empty, is the content of the Go glue script, which is inlined into the worker instead of being imported from the GlueURL.
+	GlueJS string
+
+	// Runtime is the toolchain that the WASM is built with, which defaults to RuntimeGo.
+	Runtime Runtime
+
+	// Module, if non-nil, is the compiled WASM to run, which is sent to the worker, instead of letting the worker fetch and compile the WASM from the Path.
+	// The Path is still used as the default Args[0].
+	Module *Module
+
 	worker *worker.Worker
 }
 
 func (ww *WasmWebWorker) Start() error {
-	workerJS, err := buildWorkerJS(ww.Args, ww.Env, ww.Path)
+	workerJS, err := buildWorkerJS(ww.Args, ww.Env, ww.Path, ww.bootstrapOptions())
+	if err != nil {
+		return err
+	}
+	return ww.start(workerJS)
+}
+
+func (ww *WasmWebWorker) startForConn(orderedOutput bool) error {
+	opts := ww.bootstrapOptions()
+	opts.OrderedOutput = orderedOutput
+	workerJS, err := buildWorkerConnJS(ww.Args, ww.Env, ww.Path, opts)
 	if err != nil {
 		return err
 	}
+	return ww.start(workerJS)
+}
+
+func (ww *WasmWebWorker) bootstrapOptions() bootstrapOptions {
+	return bootstrapOptions{
+		GlueURL:      ww.GlueURL,
+		GlueJS:       ww.GlueJS,
+		Runtime:      ww.Runtime,
+		SharedModule: ww.Module != nil,
+	}
+}
 
+func (ww *WasmWebWorker) start(workerJS string) error {
 	if ww.Name == "" {
 		ww.Name = uuid.New().String()
 	}
@@ -52,6 +88,14 @@ func (ww *WasmWebWorker) Start() error {
 
 	ww.worker = wk
 
+	if ww.Module != nil {
+		if err := postControlFrame(ww, frameModule, ww.Module.value, nil); err != nil {
+			wk.Terminate()
+			ww.worker = nil
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -60,8 +104,11 @@ func (ww *WasmWebWorker) PostMessage(data safejs.Value, transfers []safejs.Value
 	return ww.worker.PostMessage(data, transfers)
 }
 
-// Terminate immediately terminates the Worker.
+// Terminate immediately terminates the Worker. It is a no-op if the Worker is not started, and safe to be called more than once.
 func (ww *WasmWebWorker) Terminate() {
+	if ww.worker == nil {
+		return
+	}
 	ww.worker.Terminate()
 }
 
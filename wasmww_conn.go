@@ -3,12 +3,13 @@
 package wasmww
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"strings"
+	"log/slog"
+	"net/http"
 	"sync"
 
 	"github.com/hack-pad/safejs"
@@ -16,12 +17,6 @@ import (
 	"github.com/magodo/go-webworkers/types"
 )
 
-const CLOSE_EVENT = "__WASMWW_CLOSE__"
-const STDOUT_EVENT = "__WASMWW_STDOUT__"
-const STDERR_EVENT = "__WASMWW_STDERR__"
-const WRITE_TO_CONSOLE_EVENT = "__WASMWW_WRITE_TO_CONSOLE__"
-const WRITE_TO_CONTROLLER_EVENT = "__WASMWW_WRITE_TO_CONTROLLER"
-
 // WasmWebWorkerConn is a high level wrapper around the WasmWebWorker, which
 // provides a full duplex connection between the web worker.
 // On the web worker, it is expected to call the SelfConn.SetupConn() to build up the connection.
@@ -46,12 +41,58 @@ type WasmWebWorkerConn struct {
 	// value in the slice for each duplicate key is used.
 	Env []string
 
+	// GlueURL is the URL of the Go glue script (i.e. wasm_exec.js) imported by the worker, e.g. when the app is served under a sub-path, or from a CDN.
+	// This can be a relative path on the server, or an absolute URL. If GlueURL is empty, the "/wasm_exec.js" of the current origin is used.
+	GlueURL string
+
+	// GlueJS, if non-empty, is the content of the Go glue script, which is inlined into the worker instead of being imported from the GlueURL.
+	GlueJS string
+
+	// Runtime is the toolchain that the WASM is built with, which defaults to RuntimeGo.
+	// Stdin is not supported by RuntimeTinyGo and RuntimeWASI.
+	Runtime Runtime
+
+	// Module, if non-nil, is the compiled WASM to run, which is sent to the worker, instead of letting the worker fetch and compile the WASM from the Path.
+	// The Path is still used as the default Args[0].
+	Module *Module
+
+	// Stdin specifies the worker's standard input.
+	//
+	// If Stdin is nil, the worker's stdin is closed right after the worker starts.
+	//
+	// Otherwise, the data read from Stdin is sent to the worker, which is read by the Go program in the worker via os.Stdin.
+	// Once Stdin reaches EOF (or any read error), the worker's stdin is closed, reading from which returns io.EOF.
+	//
+	// Stdin is only supported by RuntimeGo, setting it for the other runtimes makes Start fail.
+	Stdin io.Reader
+
 	Stdout io.Writer
 	Stderr io.Writer
 
-	pipes []io.Closer
+	// Codec is used to encode and decode the values for Send and Receive.
+	// If Codec is nil, JSONCodec is used.
+	Codec Codec
+
+	// LogHandler handles the log records sent by the slog.Handler returned by SelfConn.LogHandler in the worker,
+	// with the worker name added as the "worker" attribute. If LogHandler is nil, slog.Default() is used.
+	LogHandler slog.Handler
+
+	// ErrorHandler, if non-nil, is called with the errors encountered while relaying the events of the worker, e.g. failing to write to the Stdout or Stderr.
+	// Once writing to the Stdout or Stderr fails, the subsequent output of that stream is dropped.
+	// Regardless of the ErrorHandler, the first such error is available via Err, and returned by Wait.
+	ErrorHandler func(error)
+
+	// ProcessState contains information about an exited worker,
+	// available after a call to Wait.
+	ProcessState *ProcessState
+
+	pipes    []io.Closer
+	outputCh chan OutputChunk
 
 	ww        *WasmWebWorker
+	rpc       *rpcClient
+	http      *httpClient
+	relayErr  *relayError
 	closeFunc WebWorkerCloseFunc
 	eventCh   chan types.MessageEventMessage
 	closeCh   chan any
@@ -59,20 +100,38 @@ type WasmWebWorkerConn struct {
 
 // Start starts a new Web Worker. It spins up a goroutine to receive the events from the Web Worker,
 // and exposes a channel for consuming those events, which can be accessed by the `EventChannel()` method.
-func (conn *WasmWebWorkerConn) Start() (err error) {
+//
+// Start blocks until the worker sets up the connection. If the worker fails to start the WASM, a *StartError is returned.
+func (conn *WasmWebWorkerConn) Start() error {
+	return conn.StartContext(context.Background())
+}
+
+// StartContext is like Start, but aborts waiting for the worker to set up the connection once the ctx is done.
+// In which case, the worker is terminated, and the ctx error is returned.
+func (conn *WasmWebWorkerConn) StartContext(startCtx context.Context) (err error) {
+	if conn.Stdin != nil && !conn.Runtime.supportsStdin() {
+		return fmt.Errorf("wasmww: Stdin is not supported by the %s runtime", conn.Runtime)
+	}
 	ww := &WasmWebWorker{
 		Name: conn.Name,
 		Path: conn.Path,
 		Args: conn.Args,
 		Env:  conn.Env,
+
+		GlueURL: conn.GlueURL,
+		GlueJS:  conn.GlueJS,
+		Runtime: conn.Runtime,
+		Module:  conn.Module,
 	}
-	if err := ww.Start(); err != nil {
+	orderedOutput := conn.outputCh != nil || (conn.Stdout != nil && interfaceEqual(conn.Stdout, conn.Stderr))
+	if err := ww.startForConn(orderedOutput); err != nil {
 		return err
 	}
 	if conn.Name == "" {
 		conn.Name = ww.Name
 	}
 	conn.ww = ww
+	conn.ProcessState = nil
 	ctx, cancel := context.WithCancel(context.Background())
 
 	defer func() {
@@ -90,53 +149,109 @@ func (conn *WasmWebWorkerConn) Start() (err error) {
 	// NOTE: Since JS is single-threaded, we are careful to avoid introducing a switch point until here,
 	// so that we ensure the controller started listening before the worker actually sends the initial sync event back,
 	// as otherwise, this event will be lost.
-	if _, ok := <-rawCh; !ok {
-		return fmt.Errorf("message event channel closed (due to ctx canceled)")
+	syncEvent, err := waitSyncEvent(startCtx, rawCh)
+	if err != nil {
+		ww.Terminate()
+		conn.ww = nil
+		return err
+	}
+	if frame, ok := eventControlFrame(syncEvent); ok {
+		if state, ok := parseExitFrame(frame); ok {
+			conn.ProcessState = state
+			conn.ww = nil
+			return fmt.Errorf("worker exited before setting up the connection: %w", &ExitError{ProcessState: state})
+		}
 	}
 
 	// Create a channel to relay the event from the onmessage channel to the consuming channel,
 	// except it will cancel the listening context and close the channel when the worker closes.
+	// The events are queued, so that the control frames, e.g. the output, RPC, HTTP and exit frames, are handled even if the consuming channel isn't drained.
 	var wg sync.WaitGroup
-	eventCh := make(chan types.MessageEventMessage)
+	queue := newEventQueue()
+	eventCh := queue.ch
 	closeCh := make(chan any)
+	rpc := newRPCClient()
+	httpc := newHTTPClient()
+	relayErr := newRelayError(conn.ErrorHandler)
+	outputCh := conn.outputCh
+	// broken records the streams failed to write to, whose subsequent output is dropped.
+	var broken [3]bool
+	writeOutput := func(fd int, b []byte) {
+		w, name := conn.Stdout, "stdout"
+		if fd == 2 {
+			w, name = conn.Stderr, "stderr"
+		}
+		if w != nil && !broken[fd] {
+			if _, err := w.Write(b); err != nil {
+				broken[fd] = true
+				relayErr.report(fmt.Errorf("writing to %s: %w", name, err))
+			}
+		}
+		if outputCh != nil {
+			outputCh <- OutputChunk{Fd: fd, Data: b}
+		}
+	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		var state *ProcessState
 		for event := range rawCh {
-			if data, err := event.Data(); err == nil {
-				if str, err := data.String(); err == nil {
-					if str == CLOSE_EVENT {
-						cancel()
-						continue
-					}
-					if strings.HasPrefix(str, STDOUT_EVENT) {
-						if conn.Stdout != nil {
-							if _, err := conn.Stdout.Write([]byte(str[len(STDOUT_EVENT):])); err != nil {
-								log.Fatalf("Controller writing to stdout: %v", err)
-							}
-						}
-						continue
-					}
-					if strings.HasPrefix(str, STDERR_EVENT) {
-						if conn.Stderr != nil {
-							if _, err := conn.Stderr.Write([]byte(str[len(STDERR_EVENT):])); err != nil {
-								log.Fatalf("Controller writing to stderr: %v", err)
-							}
-						}
-						continue
-					}
+			frame, ok := eventControlFrame(event)
+			if !ok {
+				queue.push(event)
+				continue
+			}
+			switch frame.Kind {
+			case frameClose:
+				state = newClosedState(parseCloseFrame(frame))
+				cancel()
+			case frameExit, frameCrash:
+				if exitState, ok := parseExitFrame(frame); ok {
+					state = exitState
+					cancel()
+				}
+			case frameStdout:
+				if b, err := bytesFromJS(frame.Payload); err == nil {
+					writeOutput(1, b)
 				}
+			case frameStderr:
+				if b, err := bytesFromJS(frame.Payload); err == nil {
+					writeOutput(2, b)
+				}
+			case frameConsole:
+				if fd, line, ok := consoleOutput(frame); ok {
+					writeOutput(fd, line)
+				}
+			case frameLog:
+				handleLogFrame(conn.LogHandler, frame)
+			case frameRPCResponse:
+				rpc.dispatch(frame)
+			case frameHTTP:
+				httpc.dispatch(frame)
+			case frameNetConnEOF:
+				queue.push(event)
 			}
-			eventCh <- event
 		}
-		close(closeCh)
-		close(eventCh)
+		// The relay only stops without a state when the controller terminates the worker.
+		if state == nil {
+			state = newTerminatedState()
+		}
+		conn.ProcessState = state
+		rpc.close()
+		httpc.close()
 
 		for _, closer := range conn.pipes {
 			closer.Close()
 		}
+		if outputCh != nil {
+			close(outputCh)
+			conn.outputCh = nil
+		}
 
 		conn.ww = nil
+
+		close(closeCh)
+		queue.close()
 	}()
 
 	conn.closeFunc = func() error {
@@ -147,12 +262,213 @@ func (conn *WasmWebWorkerConn) Start() (err error) {
 
 	conn.eventCh = eventCh
 	conn.closeCh = closeCh
+	conn.rpc = rpc
+	conn.http = httpc
+	conn.relayErr = relayErr
+
+	// The worker of the other runtimes doesn't read the stdin frames at all.
+	if conn.Runtime.supportsStdin() {
+		go copyStdin(ww, conn.Stdin)
+	}
+
 	return nil
 }
 
+// copyStdin sends the data read from the stdin to the worker as bytes, and closes the worker's stdin once the stdin reaches EOF (or any read error).
+func copyStdin(ww MessagePoster, stdin io.Reader) {
+	if stdin != nil {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				arr, transfers, err := bytesToTransferable(buf[:n])
+				if err != nil {
+					return
+				}
+				if err := postControlFrame(ww, frameStdin, arr, transfers); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	postControlFrame(ww, frameStdinEOF, nil, nil)
+}
+
 // Wait waits for the controller's internal event loop to quit. This can be caused by either worker closes itself, or controler calls `Terminate`.
-func (conn *WasmWebWorkerConn) Wait() {
+//
+// The returned error is nil if the Go program in the worker exits with status 0, or the worker closes itself,
+// and no error is encountered while relaying the events (see Err).
+// Otherwise, e.g. the Go program exits with a non-zero status, panics, or the worker is terminated, the error is of type *ExitError.
+func (conn *WasmWebWorkerConn) Wait() error {
 	<-conn.closeCh
+	if err := waitError(conn.ProcessState); err != nil {
+		return err
+	}
+	return conn.Err()
+}
+
+// CloseReason returns why the connection is closed, available once the EventChannel is closed, or after a call to Wait.
+// It tells whether the worker closed itself (with the code and reason passed to SelfConn.CloseWithReason), was terminated,
+// exited, or crashed. It returns nil if the connection is not closed yet.
+func (conn *WasmWebWorkerConn) CloseReason() *CloseReason {
+	return conn.ProcessState.CloseReason()
+}
+
+// Err returns the first error encountered while relaying the events of the worker, e.g. failing to write to the Stdout or Stderr.
+// It returns nil if there is no such error, or the worker is not started.
+func (conn *WasmWebWorkerConn) Err() error {
+	return conn.relayErr.err()
+}
+
+// Run starts the worker and waits for it to complete.
+// The events sent from the worker are discarded.
+//
+// The returned error is nil if the worker starts and exits successfully.
+// Otherwise, it is the error returned from Start or Wait.
+func (conn *WasmWebWorkerConn) Run() error {
+	if err := conn.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for range conn.EventChannel() {
+		}
+	}()
+	return conn.Wait()
+}
+
+// Output runs the worker and returns its standard output.
+// Any returned error will usually be of type *ExitError.
+// If conn.Stderr was nil, Output populates ExitError.Stderr.
+func (conn *WasmWebWorkerConn) Output() ([]byte, error) {
+	if conn.Stdout != nil {
+		return nil, errors.New("wasmww: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	conn.Stdout = &stdout
+	defer func() { conn.Stdout = nil }()
+
+	captureErr := conn.Stderr == nil
+	var stderr bytes.Buffer
+	if captureErr {
+		conn.Stderr = &stderr
+		defer func() { conn.Stderr = nil }()
+	}
+
+	err := conn.Run()
+	if err != nil && captureErr {
+		var ee *ExitError
+		if errors.As(err, &ee) {
+			ee.Stderr = stderr.Bytes()
+		}
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the worker and returns its combined standard
+// output and standard error.
+func (conn *WasmWebWorkerConn) CombinedOutput() ([]byte, error) {
+	if conn.Stdout != nil {
+		return nil, errors.New("wasmww: Stdout already set")
+	}
+	if conn.Stderr != nil {
+		return nil, errors.New("wasmww: Stderr already set")
+	}
+	var b bytes.Buffer
+	conn.Stdout = &b
+	conn.Stderr = &b
+	defer func() {
+		conn.Stdout = nil
+		conn.Stderr = nil
+	}()
+	err := conn.Run()
+	return b.Bytes(), err
+}
+
+// exited tells whether the worker has quit, after it is started.
+func (conn *WasmWebWorkerConn) exited() bool {
+	select {
+	case <-conn.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// StdinPipe returns a pipe that will be connected to the worker's
+// standard input when the worker starts.
+//
+// Closing the pipe closes the worker's stdin, after which the Go program in the worker reads io.EOF from os.Stdin.
+// Once the worker is exited (no matter closed by itself or terminated),
+// the pipe will be closed by the WasmWebWorkerConn. So no need to close
+// the pipe themselves, though it is safe to do so.
+func (conn *WasmWebWorkerConn) StdinPipe() (io.WriteCloser, error) {
+	if conn.Stdin != nil {
+		return nil, errors.New("wasmww: Stdin already set")
+	}
+	if conn.ww != nil {
+		return nil, errors.New("wasmww: StdinPipe after worker started")
+	}
+	if !conn.Runtime.supportsStdin() {
+		return nil, fmt.Errorf("wasmww: Stdin is not supported by the %s runtime", conn.Runtime)
+	}
+	r, w, err := chanio.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	wc := &closeOnceWriter{WriteCloser: w}
+	conn.Stdin = r
+	conn.pipes = append(conn.pipes, wc)
+	return wc, nil
+}
+
+// closeOnceWriter allows the pipe to be closed by both the user and the WasmWebWorkerConn.
+type closeOnceWriter struct {
+	io.WriteCloser
+	once sync.Once
+	err  error
+}
+
+func (w *closeOnceWriter) Close() error {
+	w.once.Do(func() {
+		w.err = w.WriteCloser.Close()
+	})
+	return w.err
+}
+
+// OutputChunk is a chunk of the worker's output, tagged with the file descriptor it is written to.
+type OutputChunk struct {
+	// Fd is 1 for stdout, and 2 for stderr.
+	Fd   int
+	Data []byte
+}
+
+// OutputPipe returns a channel that will receive both the worker's standard output and standard error
+// when the worker starts, in the order they are written, with each chunk tagged with its file descriptor.
+// It works together with the Stdout and Stderr, if set.
+//
+// The channel must be drained, otherwise the WasmWebWorkerConn blocks on sending to it.
+// Once the worker is exited (no matter closed by itself or terminated),
+// the channel will be closed by the WasmWebWorkerConn.
+func (conn *WasmWebWorkerConn) OutputPipe() (<-chan OutputChunk, error) {
+	if conn.outputCh != nil {
+		return nil, errors.New("wasmww: OutputPipe already called")
+	}
+	if conn.ww != nil {
+		return nil, errors.New("wasmww: OutputPipe after worker started")
+	}
+	conn.outputCh = make(chan OutputChunk)
+	return conn.outputCh, nil
+}
+
+// interfaceEqual protects against panics from doing equality tests on two interfaces with non-comparable underlying types.
+func interfaceEqual(a, b any) bool {
+	defer func() {
+		recover()
+	}()
+	return a == b
 }
 
 // StdoutPipe returns a channel that will be connected to the worker's
@@ -205,12 +521,91 @@ func (conn *WasmWebWorkerConn) PostMessage(data safejs.Value, transfers []safejs
 }
 
 // Terminate immediately terminates the Worker. Meanwhile, it stops the internal event loop, which makes the `Wait` to return.
+// It is a no-op if the worker is not started, or has quit already, hence it is safe to be called more than once.
 func (conn *WasmWebWorkerConn) Terminate() {
-	conn.ww.Terminate()
-	conn.closeFunc()
+	if ww := conn.ww; ww != nil {
+		ww.Terminate()
+	}
+	if conn.closeFunc != nil {
+		conn.closeFunc()
+	}
+}
+
+// Close terminates the worker if it is still running, and waits for the internal event loop to quit.
+func (conn *WasmWebWorkerConn) Close() error {
+	if conn.closeCh == nil {
+		return errors.New("wasmww: Close before worker started")
+	}
+	if ww := conn.ww; ww != nil {
+		ww.Terminate()
+		conn.closeFunc()
+	}
+	<-conn.closeCh
+	return nil
 }
 
 // EventChannel returns the channel that receives events sent from the Web Worker.
 func (conn *WasmWebWorkerConn) EventChannel() <-chan types.MessageEventMessage {
 	return conn.eventCh
 }
+
+// SetWriteToConsole instructs the worker to write its stdout/stderr to console.
+func (conn *WasmWebWorkerConn) SetWriteToConsole() error {
+	return postControlFrame(conn.ww, frameWriteToConsole, nil, nil)
+}
+
+// SetWriteToController instructs the worker to write its stdout/stderr to the controller, which is the default.
+func (conn *WasmWebWorkerConn) SetWriteToController() error {
+	return postControlFrame(conn.ww, frameWriteToController, nil, nil)
+}
+
+// SetWriteToDiscard instructs the worker to discard its stdout/stderr.
+func (conn *WasmWebWorkerConn) SetWriteToDiscard() error {
+	return postControlFrame(conn.ww, frameWriteToDiscard, nil, nil)
+}
+
+// SetWriteToTee instructs the worker to write its stdout/stderr to both the controller and the console.
+func (conn *WasmWebWorkerConn) SetWriteToTee() error {
+	return postControlFrame(conn.ww, frameWriteToTee, nil, nil)
+}
+
+// Call calls the method registered in the worker via SelfConn.Handle, and waits for the reply.
+// The args is JSON encoded and sent to the worker, and the returned reply is JSON decoded into the reply, if not nil.
+//
+// Multiple calls can be in-flight concurrently. Once the ctx is done, Call returns the ctx error, and the worker is notified to cancel the handling.
+// If the handler returns an error, it is returned as an *RPCError.
+func (conn *WasmWebWorkerConn) Call(ctx context.Context, method string, args any, reply any) error {
+	if conn.rpc == nil {
+		return errors.New("wasmww: Call before worker started")
+	}
+	return conn.rpc.call(ctx, conn.ww, method, args, reply)
+}
+
+// Transport returns an http.RoundTripper that sends the requests to the handler served in the worker via SelfConn.ServeHTTP.
+// The request and response bodies are streamed in chunks, and the in-flight requests are canceled once the request ctx is done,
+// or the response body is closed before EOF.
+//
+// The returned http.RoundTripper keeps working across restarts of the WasmWebWorkerConn.
+func (conn *WasmWebWorkerConn) Transport() http.RoundTripper {
+	return &httpTransport{
+		client: func() (*httpClient, MessagePoster) {
+			if conn.ww == nil {
+				return nil, nil
+			}
+			return conn.http, conn.ww
+		},
+	}
+}
+
+// Send encodes v with the Codec, and sends it to the worker.
+func (conn *WasmWebWorkerConn) Send(v any) error {
+	return send(conn.ww, conn.Codec, v)
+}
+
+// Receive receives the next event sent from the worker, and decodes it with the Codec into v.
+// It returns io.EOF once the worker exits.
+//
+// Receive consumes the EventChannel(), hence it shall not be used together with other consumers of the channel.
+func (conn *WasmWebWorkerConn) Receive(v any) error {
+	return receive(conn.eventCh, conn.Codec, v)
+}
@@ -0,0 +1,569 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"syscall/js"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/hack-pad/safejs"
+	"github.com/magodo/go-webworkers/types"
+)
+
+// testWASMPath is the absolute URL of the WASM of the fake workers, as there is no origin in node to resolve a relative path against.
+const testWASMPath = "http://localhost/test.wasm"
+
+// fakeWorkersJS replaces the Worker and SharedWorker constructors, which are missing in node, with ones backed by a MessageChannel.
+// The bootstrap script isn't run, instead, the peer port of each created worker is recorded, via which the tests play the role of the worker.
+const fakeWorkersJS = `
+const workers = [];
+globalThis.__wasmww_test_workers__ = workers;
+globalThis.Worker = function (url, options) {
+    const channel = new MessageChannel();
+    const record = {url: url, name: (options && options.name) || "", peer: channel.port2, terminated: false};
+    channel.port1.terminate = () => {
+        record.terminated = true;
+        channel.port1.close();
+    };
+    workers.push(record);
+    return channel.port1;
+};
+globalThis.SharedWorker = function (url, name) {
+    const channel = new MessageChannel();
+    workers.push({url: url, name: name, peer: channel.port2, terminated: false});
+    return {port: channel.port1};
+};
+`
+
+// jsWorkers tracks the workers created by the fake Worker and SharedWorker constructors.
+type jsWorkers struct {
+	t     *testing.T
+	count int
+}
+
+// installJSWorkers installs the fake Worker and SharedWorker constructors for the test.
+func installJSWorkers(t *testing.T) *jsWorkers {
+	t.Helper()
+	js.Global().Get("Function").New(fakeWorkersJS).Invoke()
+	t.Cleanup(func() {
+		workers := js.Global().Get("__wasmww_test_workers__")
+		for i := 0; i < workers.Length(); i++ {
+			workers.Index(i).Get("peer").Call("close")
+		}
+		js.Global().Delete("Worker")
+		js.Global().Delete("SharedWorker")
+	})
+	return &jsWorkers{t: t}
+}
+
+// next waits for the next worker to be created, and starts listening on its peer port.
+func (f *jsWorkers) next() *jsWorker {
+	f.t.Helper()
+	workers := js.Global().Get("__wasmww_test_workers__")
+	deadline := time.Now().Add(5 * time.Second)
+	for workers.Length() <= f.count {
+		if time.Now().After(deadline) {
+			f.t.Fatalf("timeout waiting for the worker #%d to be created", f.count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	record := workers.Index(f.count)
+	f.count++
+
+	peer, err := types.WrapMessagePort(safejs.Safe(record.Get("peer")))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.t.Cleanup(cancel)
+	events, err := peer.Listen(ctx)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return &jsWorker{t: f.t, record: record, peer: peer, events: events}
+}
+
+// jsWorker is the worker side of a fake worker, played by the test.
+type jsWorker struct {
+	t      *testing.T
+	record js.Value
+	peer   *types.MessagePort
+	events <-chan types.MessageEventMessage
+}
+
+func (w *jsWorker) url() string {
+	return w.record.Get("url").String()
+}
+
+func (w *jsWorker) terminated() bool {
+	return w.record.Get("terminated").Bool()
+}
+
+// script returns the bootstrap script that the worker is created with, which is read from the object URL.
+func (w *jsWorker) script() string {
+	w.t.Helper()
+	blob := js.Global().Call("require", "buffer").Call("resolveObjectURL", w.url())
+	if blob.IsUndefined() {
+		w.t.Fatalf("the worker URL %s is not an object URL", w.url())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	text, err := awaitPromise(ctx, safejs.Safe(blob.Call("text")))
+	if err != nil {
+		w.t.Fatal(err)
+	}
+	return safejs.Unsafe(text).String()
+}
+
+// PostMessage posts the message to the controller.
+func (w *jsWorker) PostMessage(message safejs.Value, transfers []safejs.Value) error {
+	return w.peer.PostMessage(message, transfers)
+}
+
+// post posts the message, which is either a safejs.Value, or any value accepted by js.ValueOf, to the controller.
+func (w *jsWorker) post(message any) {
+	w.t.Helper()
+	if v, ok := message.(safejs.Value); ok {
+		message = safejs.Unsafe(v)
+	}
+	if err := w.peer.PostMessage(safejs.Safe(js.ValueOf(message)), nil); err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// postFrame posts the control frame to the controller.
+func (w *jsWorker) postFrame(kind frameKind, payload any) {
+	w.t.Helper()
+	if err := postControlFrame(w.peer, kind, payload, nil); err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// nextFrame waits for the next control frame of the kind sent by the controller, skipping the others.
+func (w *jsWorker) nextFrame(kind frameKind) controlFrame {
+	w.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-w.events:
+			if !ok {
+				w.t.Fatalf("the worker is closed before receiving the %s frame", kind)
+			}
+			if frame, ok := eventControlFrame(event); ok && frame.Kind == kind {
+				return frame
+			}
+		case <-timeout:
+			w.t.Fatalf("timeout waiting for the %s frame", kind)
+		}
+	}
+}
+
+// nextMessage waits for the next user message sent by the controller, skipping the control frames, and returns its data.
+func (w *jsWorker) nextMessage() safejs.Value {
+	w.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-w.events:
+			if !ok {
+				w.t.Fatal("the worker is closed before receiving the message")
+			}
+			if _, ok := eventControlFrame(event); ok {
+				continue
+			}
+			data, err := event.Data()
+			if err != nil {
+				w.t.Fatal(err)
+			}
+			return data
+		case <-timeout:
+			w.t.Fatal("timeout waiting for the message")
+		}
+	}
+}
+
+// serve dispatches the RPC and HTTP frames sent by the controller to the servers, until the worker is closed.
+func (w *jsWorker) serve(rpc *rpcServer, http *httpServer) {
+	go func() {
+		for event := range w.events {
+			frame, ok := eventControlFrame(event)
+			if !ok {
+				continue
+			}
+			switch frame.Kind {
+			case frameRPCRequest, frameRPCCancel:
+				if rpc != nil {
+					rpc.dispatch(w.peer, frame)
+				}
+			case frameHTTP:
+				if http != nil {
+					http.dispatch(w.peer, frame)
+				}
+			}
+		}
+	}()
+}
+
+// startJSConn starts the conn with a fake worker, which is ready to set up the connection.
+func startJSConn(t *testing.T, workers *jsWorkers, conn *WasmWebWorkerConn) *jsWorker {
+	t.Helper()
+	if conn.Path == "" {
+		conn.Path = testWASMPath
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- conn.Start()
+	}()
+	w := workers.next()
+	// The initial sync event.
+	w.post(nil)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// waitTimeout calls the wait, and fails the test if it doesn't return in time.
+func waitTimeout(t *testing.T, wait func() error) error {
+	t.Helper()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- wait()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting")
+		return nil
+	}
+}
+
+// recordPoster records the messages posted to it.
+type recordPoster struct {
+	messages []safejs.Value
+}
+
+func (p *recordPoster) PostMessage(message safejs.Value, transfers []safejs.Value) error {
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// frames returns the control frames posted, it fails the test if any message is not a control frame.
+func (p *recordPoster) frames(t *testing.T) []controlFrame {
+	t.Helper()
+	var frames []controlFrame
+	for _, msg := range p.messages {
+		frame, ok := parseControlFrame(msg)
+		if !ok {
+			t.Fatalf("the message is not a control frame")
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func TestCopyStdin(t *testing.T) {
+	// The invalid UTF-8 bytes, and the runes split across the reads, are sent untouched.
+	binary := "\x00\xff\xfe日本語🚀\x80"
+	cases := map[string]struct {
+		input string
+		split bool
+	}{
+		"binary": {
+			input: binary,
+		},
+		"one byte per read": {
+			input: binary,
+			split: true,
+		},
+		"rune across the buffer boundary": {
+			input: strings.Repeat("a", 32*1024-1) + "日本語" + binary,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := bytes.NewReader([]byte(c.input))
+			poster := &recordPoster{}
+			if c.split {
+				copyStdin(poster, iotest.OneByteReader(r))
+			} else {
+				copyStdin(poster, r)
+			}
+
+			frames := poster.frames(t)
+			if len(frames) == 0 || frames[len(frames)-1].Kind != frameStdinEOF {
+				t.Fatalf("expect the last frame to be %s", frameStdinEOF)
+			}
+			var got []byte
+			for _, frame := range frames[:len(frames)-1] {
+				if frame.Kind != frameStdin {
+					t.Fatalf("unexpected frame kind %s", frame.Kind)
+				}
+				b, err := bytesFromJS(frame.Payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, b...)
+			}
+			if string(got) != c.input {
+				t.Errorf("got %q, want %q", got, c.input)
+			}
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		poster := &recordPoster{}
+		copyStdin(poster, nil)
+		if frames := poster.frames(t); len(frames) != 1 || frames[0].Kind != frameStdinEOF {
+			t.Errorf("expect only the %s frame, got %v", frameStdinEOF, frames)
+		}
+	})
+}
+
+func TestWasmWebWorkerConnStdinUnsupported(t *testing.T) {
+	for _, runtime := range []Runtime{RuntimeTinyGo, RuntimeWASI} {
+		t.Run(runtime.String(), func(t *testing.T) {
+			installJSWorkers(t)
+			conn := &WasmWebWorkerConn{Path: testWASMPath, Runtime: runtime, Stdin: strings.NewReader("input")}
+			if err := conn.Start(); err == nil {
+				conn.Terminate()
+				t.Fatal("expect Start to fail with the Stdin set")
+			}
+			if n := js.Global().Get("__wasmww_test_workers__").Length(); n != 0 {
+				t.Errorf("expect no worker to be created, got %d", n)
+			}
+
+			conn = &WasmWebWorkerConn{Path: testWASMPath, Runtime: runtime}
+			if _, err := conn.StdinPipe(); err == nil {
+				t.Error("expect StdinPipe to fail")
+			}
+		})
+	}
+}
+
+func TestWasmWebWorkerConnUndrainedEvents(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{}
+	w := startJSConn(t, workers, conn)
+	var server rpcServer
+	server.handle("echo", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return params, nil
+	})
+	w.serve(&server, nil)
+
+	// The user messages are not consumed, which must not block the RPC, nor the exit.
+	for i := 0; i < 3; i++ {
+		w.post(i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var reply string
+	if err := conn.Call(ctx, "echo", "hello", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hello" {
+		t.Errorf("got reply %q, want %q", reply, "hello")
+	}
+	w.postFrame(frameExit, 0)
+	if err := waitTimeout(t, conn.Wait); err != nil {
+		t.Fatal(err)
+	}
+
+	// The queued user messages are still delivered after the worker exits.
+	var got []int
+	for event := range conn.EventChannel() {
+		data, err := event.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		i, err := data.Int()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, i)
+	}
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("got events %v, want [0 1 2]", got)
+	}
+}
+
+func TestWasmWebWorkerConnTerminate(t *testing.T) {
+	// Terminating a conn that isn't started is a no-op.
+	(&WasmWebWorkerConn{}).Terminate()
+
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{}
+	w := startJSConn(t, workers, conn)
+	conn.Terminate()
+	conn.Terminate()
+	if !w.terminated() {
+		t.Error("expect the worker to be terminated")
+	}
+	if err := waitTimeout(t, conn.Wait); !conn.ProcessState.Terminated() {
+		t.Errorf("expect Wait to report the termination, got %v", err)
+	}
+	if reason := conn.CloseReason(); reason == nil || reason.Kind != CloseTerminated {
+		t.Errorf("got close reason %v, want %s", reason, CloseTerminated)
+	}
+}
+
+func TestWasmWebWorkerConnCloseReason(t *testing.T) {
+	workers := installJSWorkers(t)
+	cases := map[string]struct {
+		// close closes the connection, from the worker w or the controller conn.
+		close func(t *testing.T, w *jsWorker, conn *WasmWebWorkerConn)
+		want  CloseReason
+	}{
+		"worker closed": {
+			close: func(t *testing.T, w *jsWorker, conn *WasmWebWorkerConn) {
+				if err := postCloseFrame(w, closeMessage{Code: 3, Reason: "bye"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: CloseReason{Kind: CloseWorkerClosed, Code: 3, Reason: "bye"},
+		},
+		"exited": {
+			close: func(t *testing.T, w *jsWorker, conn *WasmWebWorkerConn) {
+				w.postFrame(frameExit, 4)
+			},
+			want: CloseReason{Kind: CloseExited, Code: 4},
+		},
+		"crashed": {
+			close: func(t *testing.T, w *jsWorker, conn *WasmWebWorkerConn) {
+				w.postFrame(frameCrash, "unreachable")
+			},
+			want: CloseReason{Kind: CloseCrashed, Reason: "unreachable"},
+		},
+		"terminated": {
+			close: func(t *testing.T, w *jsWorker, conn *WasmWebWorkerConn) {
+				conn.Terminate()
+			},
+			want: CloseReason{Kind: CloseTerminated},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			conn := &WasmWebWorkerConn{}
+			w := startJSConn(t, workers, conn)
+			if reason := conn.CloseReason(); reason != nil {
+				t.Fatalf("expect no close reason before closed, got %v", reason)
+			}
+			c.close(t, w, conn)
+			waitTimeout(t, conn.Wait)
+			if reason := conn.CloseReason(); reason == nil || *reason != c.want {
+				t.Errorf("got the close reason %v, want %v", reason, &c.want)
+			}
+		})
+	}
+}
+
+func TestWasmWebWorkerConnRuntime(t *testing.T) {
+	workers := installJSWorkers(t)
+	for _, runtime := range []Runtime{RuntimeGo, RuntimeTinyGo, RuntimeWASI} {
+		t.Run(runtime.String(), func(t *testing.T) {
+			conn := &WasmWebWorkerConn{Path: testWASMPath, Args: []string{"a"}, Env: []string{"K=V"}, Runtime: runtime}
+			w := startJSConn(t, workers, conn)
+			defer conn.Terminate()
+
+			want, err := buildWorkerConnJS(conn.Args, conn.Env, conn.Path, bootstrapOptions{Runtime: runtime})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := w.script()
+			if got != want {
+				t.Errorf("the worker is not started with the bootstrap script of the %s runtime", runtime)
+			}
+			// Only the TinyGo bootstrap captures the output from the fd_write import.
+			if captured := strings.Contains(got, writeSyncVar); captured != (runtime == RuntimeTinyGo) {
+				t.Errorf("got the TinyGo output capturing %t in the bootstrap script of the %s runtime", captured, runtime)
+			}
+		})
+	}
+
+	t.Run("wasi without conn", func(t *testing.T) {
+		ww := &WasmWebWorker{Path: testWASMPath, Runtime: RuntimeWASI}
+		if err := ww.Start(); !errors.Is(err, errUnsupportedWASI) {
+			t.Errorf("expect errUnsupportedWASI, got %v", err)
+		}
+		sww := &WasmSharedWebWorker{Path: testWASMPath, Runtime: RuntimeWASI}
+		if err := sww.Start(); !errors.Is(err, errUnsupportedWASI) {
+			t.Errorf("expect errUnsupportedWASI for the Shared Web Worker, got %v", err)
+		}
+	})
+}
+
+func TestWasmWebWorkerConnSetWriteTo(t *testing.T) {
+	workers := installJSWorkers(t)
+	conn := &WasmWebWorkerConn{}
+	w := startJSConn(t, workers, conn)
+
+	for kind, set := range map[frameKind]func() error{
+		frameWriteToConsole:    conn.SetWriteToConsole,
+		frameWriteToController: conn.SetWriteToController,
+		frameWriteToDiscard:    conn.SetWriteToDiscard,
+		frameWriteToTee:        conn.SetWriteToTee,
+	} {
+		if err := set(); err != nil {
+			t.Fatal(err)
+		}
+		w.nextFrame(kind)
+	}
+}
+
+func TestWasmWebWorkerConnOutput(t *testing.T) {
+	workers := installJSWorkers(t)
+
+	t.Run("exit error", func(t *testing.T) {
+		conn := &WasmWebWorkerConn{Path: testWASMPath}
+		type result struct {
+			out []byte
+			err error
+		}
+		resultCh := make(chan result, 1)
+		go func() {
+			out, err := conn.Output()
+			resultCh <- result{out, err}
+		}()
+		w := workers.next()
+		w.post(nil)
+		w.postFrame(frameStdout, safejs.Safe(js.Global().Get("Uint8Array").New(js.ValueOf([]any{'o', 'k'}))))
+		w.postFrame(frameStderr, safejs.Safe(js.Global().Get("Uint8Array").New(js.ValueOf([]any{'e', 'r', 'r'}))))
+		w.postFrame(frameExit, 2)
+		res := <-resultCh
+		if string(res.out) != "ok" {
+			t.Errorf("got stdout %q, want %q", res.out, "ok")
+		}
+		var ee *ExitError
+		if !errors.As(res.err, &ee) {
+			t.Fatalf("expect an *ExitError, got %v", res.err)
+		}
+		if ee.ExitCode() != 2 || string(ee.Stderr) != "err" {
+			t.Errorf("got exit code %d and stderr %q, want 2 and %q", ee.ExitCode(), ee.Stderr, "err")
+		}
+	})
+
+	t.Run("wrapped exit error", func(t *testing.T) {
+		conn := &WasmWebWorkerConn{Path: testWASMPath}
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := conn.Output()
+			errCh <- err
+		}()
+		// The worker exits before setting up the connection, whose ExitError is wrapped.
+		w := workers.next()
+		w.postFrame(frameExit, 3)
+		err := <-errCh
+		var ee *ExitError
+		if !errors.As(err, &ee) || ee.ExitCode() != 3 {
+			t.Fatalf("expect a wrapped *ExitError of code 3, got %v", err)
+		}
+	})
+}
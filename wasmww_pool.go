@@ -0,0 +1,334 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/magodo/go-webworkers/types"
+)
+
+// WasmWebWorkerPoolJob is a job submitted to the WasmWebWorkerPool. It is run with an idle worker of the pool,
+// which is exclusively used by this job until it returns.
+//
+// If the job closes (or terminates) the worker, the pool will re-spawn it before dispatching the next job to it.
+// The events sent by the worker are only meant to be consumed within the job, the ones left in the EventChannel are discarded before the next job.
+type WasmWebWorkerPoolJob func(conn *WasmWebWorkerConn)
+
+// WasmWebWorkerPool starts a fixed number of identical WasmWebWorkerConn, and dispatches the submitted jobs to the idle ones.
+// Jobs submitted when all the workers are busy are queued, until one of the workers becomes idle.
+type WasmWebWorkerPool struct {
+	// Name is used as the prefix of the worker names, each worker is named as "<Name>-<index>".
+	// If this is not specified, `Start` will create a UUIDv4 for it and populate back.
+	Name string
+
+	// Path is the path of the WASM to run as the Web Worker.
+	// This can be a relative path on the server, or an abosolute URL.
+	Path string
+
+	// Args holds command line arguments, including the WASM as Args[0].
+	// If the Args field is empty or nil, Run uses {Path}.
+	Args []string
+
+	// Env specifies the environment of the process.
+	// Each entry is of the form "key=value".
+	// If Env is nil, the new Web Worker uses the current context's
+	// environment.
+	// If Env contains duplicate environment keys, only the last
+	// value in the slice for each duplicate key is used.
+	Env []string
+
+	// GlueURL and GlueJS specify the Go glue script of the workers, see WasmWebWorkerConn.
+	GlueURL string
+	GlueJS  string
+
+	// Runtime is the toolchain that the WASM is built with, see WasmWebWorkerConn.
+	Runtime Runtime
+
+	// Module is the compiled WASM shared by the workers. If this is not specified, `Start` compiles the WASM from the Path once and populates back,
+	// so that neither the workers nor the re-spawned ones fetch and compile the WASM again.
+	Module *Module
+
+	// Size is the number of workers in the pool, which must be positive.
+	Size int
+
+	// QueueSize is the number of jobs that can be queued when all the workers are busy.
+	// Once the queue is full, Submit blocks until a queued job is picked up by a worker.
+	QueueSize int
+
+	// Stdout and Stderr are shared by all the workers in the pool. Writes from different workers are serialized.
+	// If Stdout and Stderr are the same writer, the stdout and stderr of each worker are combined in the order they are written.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// JobErrorHandler, if non-nil, is called with each job that can't be run, otherwise, such jobs are dropped. They are the job dispatched to
+	// a worker that fails to re-spawn, together with the re-spawning error, and the queued jobs once all the workers of the pool are retired
+	// after failing to re-spawn (see Wait), together with the error wrapping ErrPoolNoLiveWorker.
+	JobErrorHandler func(job WasmWebWorkerPoolJob, err error)
+
+	mu       sync.RWMutex
+	closed   bool
+	closedCh chan struct{}
+	jobCh    chan WasmWebWorkerPoolJob
+	wg       sync.WaitGroup
+
+	// submitWg tracks the in-flight Submit calls, the jobCh is only closed after they return.
+	submitWg sync.WaitGroup
+
+	// live is the number of workers that are still serving jobs, err records the first error that makes a worker retire.
+	// deadCh is closed once live drops to 0.
+	stateMu sync.Mutex
+	live    int
+	err     error
+	deadCh  chan struct{}
+}
+
+// poolStartConn and poolTerminateConn start and terminate a worker of the pools.
+// They are only replaced by the tests, as there is no Web Worker in the test environment.
+var (
+	poolStartConn = func(ctx context.Context, conn *WasmWebWorkerConn) error {
+		return conn.StartContext(ctx)
+	}
+	poolTerminateConn = func(conn *WasmWebWorkerConn) {
+		conn.Terminate()
+	}
+)
+
+// ErrPoolNoLiveWorker is returned by Submit, and passed to the JobErrorHandler, once all the workers of the pool are retired.
+var ErrPoolNoLiveWorker = errors.New("wasmww: no live worker in the pool")
+
+// Start starts all the workers of the pool, and begins dispatching the submitted jobs.
+// If any of the workers fails to start, the already started ones are terminated.
+func (p *WasmWebWorkerPool) Start() error {
+	return p.StartContext(context.Background())
+}
+
+// StartContext is like Start, but aborts compiling the Module and waiting for the workers to set up the connections once the ctx is done.
+// In which case, the ctx error is returned. The ctx only applies to the start, the workers re-spawned afterwards are not affected.
+func (p *WasmWebWorkerPool) StartContext(ctx context.Context) error {
+	if p.Size <= 0 {
+		return fmt.Errorf("wasmww: invalid pool size %d", p.Size)
+	}
+	if p.jobCh != nil {
+		return errors.New("wasmww: pool already started")
+	}
+	if p.Name == "" {
+		p.Name = uuid.New().String()
+	}
+
+	if p.Module == nil {
+		module, err := CompileModule(ctx, p.Path)
+		if err != nil {
+			return fmt.Errorf("compiling the module: %w", err)
+		}
+		p.Module = module
+	}
+
+	// The same writer is wrapped only once, so that the writes to it are serialized by one lock,
+	// and the workers still combine their stdout and stderr in order (see WasmWebWorkerConn.Stdout).
+	var stdout, stderr io.Writer
+	if p.Stdout != nil {
+		stdout = &lockedWriter{w: p.Stdout}
+	}
+	if p.Stderr != nil {
+		if interfaceEqual(p.Stdout, p.Stderr) {
+			stderr = stdout
+		} else {
+			stderr = &lockedWriter{w: p.Stderr}
+		}
+	}
+
+	var conns []*WasmWebWorkerConn
+	for i := 0; i < p.Size; i++ {
+		conn := &WasmWebWorkerConn{
+			Name:    fmt.Sprintf("%s-%d", p.Name, i),
+			Path:    p.Path,
+			Args:    p.Args,
+			Env:     p.Env,
+			GlueURL: p.GlueURL,
+			GlueJS:  p.GlueJS,
+			Runtime: p.Runtime,
+			Module:  p.Module,
+			Stdout:  stdout,
+			Stderr:  stderr,
+		}
+		if err := poolStartConn(ctx, conn); err != nil {
+			for _, conn := range conns {
+				poolTerminateConn(conn)
+			}
+			return fmt.Errorf("starting worker %s: %w", conn.Name, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	p.jobCh = make(chan WasmWebWorkerPoolJob, p.QueueSize)
+	p.closedCh = make(chan struct{})
+	p.deadCh = make(chan struct{})
+	p.live = len(conns)
+	for _, conn := range conns {
+		p.wg.Add(1)
+		go p.serve(conn)
+	}
+	return nil
+}
+
+// serve runs the jobs on the conn, until the pool is closed and all the queued jobs are consumed.
+func (p *WasmWebWorkerPool) serve(conn *WasmWebWorkerConn) {
+	defer p.wg.Done()
+	for job := range p.jobCh {
+		// The liveness is checked right before running the job, instead of right after the previous one, as by then,
+		// the close or exit frame of a worker that is quitting might not have been relayed yet.
+		if conn.exited() {
+			// The worker quits during or after the previous job, re-spawn it for this and the following jobs.
+			drainEvents(conn.EventChannel())
+			if err := poolStartConn(context.Background(), conn); err != nil {
+				err = fmt.Errorf("re-spawning worker %s: %w", conn.Name, err)
+				if p.JobErrorHandler != nil {
+					p.JobErrorHandler(job, err)
+				}
+				p.retire(err)
+				return
+			}
+		}
+		discardEvents(conn.EventChannel())
+		job(conn)
+	}
+	if !conn.exited() {
+		poolTerminateConn(conn)
+	}
+	drainEvents(conn.EventChannel())
+}
+
+// discardEvents discards the events left in the channel by the previous job.
+func discardEvents(ch <-chan types.MessageEventMessage) {
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// drainEvents drains the channel of a quit worker in the background, until it is closed after delivering the queued events.
+func drainEvents(ch <-chan types.MessageEventMessage) {
+	if ch == nil {
+		return
+	}
+	go func() {
+		for range ch {
+		}
+	}()
+}
+
+// retire records that a worker stops serving jobs due to the err. Once the last worker is retired,
+// the queued jobs, and the ones submitted until the pool is closed, are failed with the JobErrorHandler.
+func (p *WasmWebWorkerPool) retire(err error) {
+	p.stateMu.Lock()
+	p.live--
+	if p.err == nil {
+		p.err = err
+	}
+	live := p.live
+	if live == 0 {
+		close(p.deadCh)
+	}
+	p.stateMu.Unlock()
+
+	if live > 0 {
+		return
+	}
+	for job := range p.jobCh {
+		if p.JobErrorHandler != nil {
+			p.JobErrorHandler(job, p.noLiveWorkerError())
+		}
+	}
+}
+
+// noLiveWorkerError returns the error for the jobs that can't be run, as all the workers are retired.
+func (p *WasmWebWorkerPool) noLiveWorkerError() error {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	return fmt.Errorf("%w: %w", ErrPoolNoLiveWorker, p.err)
+}
+
+// Submit submits a job to the pool. The job is run immediately if there is an idle worker, otherwise, it is queued.
+// Submit blocks if the queue is full, until the job is queued, the pool is closed, or all the workers are retired.
+func (p *WasmWebWorkerPool) Submit(job WasmWebWorkerPoolJob) error {
+	p.mu.RLock()
+	if p.jobCh == nil {
+		p.mu.RUnlock()
+		return errors.New("wasmww: Submit before pool started")
+	}
+	if p.closed {
+		p.mu.RUnlock()
+		return errors.New("wasmww: Submit after pool closed")
+	}
+	p.submitWg.Add(1)
+	p.mu.RUnlock()
+	defer p.submitWg.Done()
+
+	select {
+	case <-p.deadCh:
+		return p.noLiveWorkerError()
+	default:
+	}
+	select {
+	case p.jobCh <- job:
+		return nil
+	case <-p.closedCh:
+		return errors.New("wasmww: Submit after pool closed")
+	case <-p.deadCh:
+		return p.noLiveWorkerError()
+	}
+}
+
+// Close stops the pool from accepting new jobs. The queued jobs are still run, after which the workers are terminated.
+// Use `Wait` to wait for the pool to quit.
+func (p *WasmWebWorkerPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.jobCh == nil {
+		return errors.New("wasmww: Close before pool started")
+	}
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.closedCh)
+	// The blocked Submit calls return once the closedCh is closed, after which no more job can be sent.
+	go func() {
+		p.submitWg.Wait()
+		close(p.jobCh)
+	}()
+	return nil
+}
+
+// Wait waits for all the workers of the pool to quit after `Close`.
+// It returns the error, if any, that happened when re-spawning the workers.
+func (p *WasmWebWorkerPool) Wait() error {
+	p.wg.Wait()
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	return p.err
+}
+
+// lockedWriter serializes the writes to the underlying writer, which is shared by multiple workers.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
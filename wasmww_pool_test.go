@@ -0,0 +1,354 @@
+//go:build js && wasm
+
+package wasmww
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/magodo/go-webworkers/types"
+)
+
+// fakeWorkers starts and terminates the workers of a pool without Web Workers, only the closeCh and eventCh of the conn are maintained.
+type fakeWorkers struct {
+	mu sync.Mutex
+	// starts is the number of the successful starts, once it reaches the maxStarts (if positive), the start fails.
+	starts    int
+	maxStarts int
+}
+
+func (f *fakeWorkers) start(ctx context.Context, conn *WasmWebWorkerConn) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.maxStarts > 0 && f.starts >= f.maxStarts {
+		return errors.New("start failed")
+	}
+	f.starts++
+	conn.closeCh = make(chan any)
+	conn.eventCh = make(chan types.MessageEventMessage, 8)
+	return nil
+}
+
+// terminate simulates the worker quits.
+func (f *fakeWorkers) terminate(conn *WasmWebWorkerConn) {
+	close(conn.closeCh)
+	close(conn.eventCh)
+}
+
+func (f *fakeWorkers) startCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.starts
+}
+
+// newFakePool returns a pool whose workers are started and terminated by the fakeWorkers during the test.
+func newFakePool(t *testing.T, f *fakeWorkers, size, queueSize int) *WasmWebWorkerPool {
+	start, terminate := poolStartConn, poolTerminateConn
+	poolStartConn, poolTerminateConn = f.start, f.terminate
+	t.Cleanup(func() {
+		poolStartConn, poolTerminateConn = start, terminate
+	})
+	return &WasmWebWorkerPool{
+		Name:      "pool",
+		Module:    &Module{},
+		Size:      size,
+		QueueSize: queueSize,
+	}
+}
+
+func TestPoolDispatch(t *testing.T) {
+	f := &fakeWorkers{}
+	pool := newFakePool(t, f, 2, 4)
+	if err := pool.Submit(func(*WasmWebWorkerConn) {}); err == nil {
+		t.Fatal("expect Submit before Start to fail")
+	}
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Start(); err == nil {
+		t.Fatal("expect starting twice to fail")
+	}
+
+	var mu sync.Mutex
+	names := map[string]int{}
+	var conns []*WasmWebWorkerConn
+	for i := 0; i < 20; i++ {
+		if err := pool.Submit(func(conn *WasmWebWorkerConn) {
+			mu.Lock()
+			defer mu.Unlock()
+			if names[conn.Name] == 0 {
+				conns = append(conns, conn)
+			}
+			names[conn.Name]++
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Submit(func(*WasmWebWorkerConn) {}); err == nil {
+		t.Fatal("expect Submit after Close to fail")
+	}
+	if err := pool.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for name, n := range names {
+		if name != "pool-0" && name != "pool-1" {
+			t.Errorf("unexpected worker name %q", name)
+		}
+		total += n
+	}
+	if total != 20 {
+		t.Errorf("expect 20 jobs to run, got %d", total)
+	}
+	for _, conn := range conns {
+		if !conn.exited() {
+			t.Errorf("worker %s is not terminated after the pool quits", conn.Name)
+		}
+	}
+	if n := f.startCount(); n != 2 {
+		t.Errorf("expect 2 workers started, got %d", n)
+	}
+}
+
+func TestPoolRespawn(t *testing.T) {
+	f := &fakeWorkers{}
+	pool := newFakePool(t, f, 1, 4)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ran := 0
+	for i := 0; i < 3; i++ {
+		if err := pool.Submit(func(conn *WasmWebWorkerConn) {
+			if conn.exited() {
+				t.Error("the job is dispatched to an exited worker")
+			}
+			ran++
+			// Simulate the worker quits during the job.
+			f.terminate(conn)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Close()
+	if err := pool.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if ran != 3 {
+		t.Errorf("expect 3 jobs to run, got %d", ran)
+	}
+	// The worker is re-spawned right before the 2nd and 3rd jobs, but not after the last job.
+	if n := f.startCount(); n != 3 {
+		t.Errorf("expect the worker to be started 3 times, got %d", n)
+	}
+}
+
+func TestPoolRetire(t *testing.T) {
+	f := &fakeWorkers{maxStarts: 1}
+	pool := newFakePool(t, f, 1, 2)
+	failed := make(chan error, 2)
+	pool.JobErrorHandler = func(job WasmWebWorkerPoolJob, err error) {
+		failed <- err
+	}
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	if err := pool.Submit(func(conn *WasmWebWorkerConn) {
+		<-release
+		f.terminate(conn)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Queue the jobs while the only worker is busy, which are failed once the worker fails to re-spawn:
+	// the first one is dispatched to the worker, the second one is left in the queue.
+	for i := 0; i < 2; i++ {
+		if err := pool.Submit(func(*WasmWebWorkerConn) {
+			t.Error("the job is run without a live worker")
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	if err := <-failed; err == nil || !strings.Contains(err.Error(), "re-spawning worker pool-0") {
+		t.Errorf("expect the dispatched job to fail with the re-spawning error, got %v", err)
+	}
+	if err := <-failed; !errors.Is(err, ErrPoolNoLiveWorker) {
+		t.Errorf("expect the queued job to fail with ErrPoolNoLiveWorker, got %v", err)
+	}
+	if err := pool.Submit(func(*WasmWebWorkerConn) {}); !errors.Is(err, ErrPoolNoLiveWorker) {
+		t.Errorf("expect Submit to fail with ErrPoolNoLiveWorker, got %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Wait(); err == nil || !strings.Contains(err.Error(), "re-spawning worker pool-0") {
+		t.Errorf("expect Wait to return the re-spawning error, got %v", err)
+	}
+}
+
+func TestPoolCloseUnblocksSubmit(t *testing.T) {
+	f := &fakeWorkers{}
+	pool := newFakePool(t, f, 1, 0)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	if err := pool.Submit(func(*WasmWebWorkerConn) { <-release }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker is busy and there is no queue, hence the Submit blocks until the pool is closed.
+	submitted := make(chan error)
+	go func() {
+		submitted <- pool.Submit(func(*WasmWebWorkerConn) {
+			t.Error("the job is run after the Submit fails")
+		})
+	}()
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-submitted; err == nil {
+		t.Error("expect the blocked Submit to fail once the pool is closed")
+	}
+	close(release)
+	if err := pool.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolStartFailure(t *testing.T) {
+	f := &fakeWorkers{maxStarts: 2}
+	var terminated []string
+	pool := newFakePool(t, f, 3, 0)
+	poolTerminateConn = func(conn *WasmWebWorkerConn) {
+		terminated = append(terminated, conn.Name)
+		f.terminate(conn)
+	}
+	if err := pool.Start(); err == nil || !strings.Contains(err.Error(), "starting worker pool-2") {
+		t.Fatalf("expect starting the third worker to fail, got %v", err)
+	}
+	if strings.Join(terminated, ",") != "pool-0,pool-1" {
+		t.Errorf("expect the started workers to be terminated, got %v", terminated)
+	}
+}
+
+func TestPoolStartContext(t *testing.T) {
+	f := &fakeWorkers{}
+	pool := newFakePool(t, f, 2, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pool.StartContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect the start to be aborted by the ctx, got %v", err)
+	}
+	if n := f.startCount(); n != 0 {
+		t.Errorf("expect no worker to be started, got %d", n)
+	}
+}
+
+func TestPoolLateExit(t *testing.T) {
+	f := &fakeWorkers{}
+	pool := newFakePool(t, f, 1, 0)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *WasmWebWorkerConn)
+	if err := pool.Submit(func(conn *WasmWebWorkerConn) {
+		done <- conn
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate the close frame of the worker is relayed after the job returns.
+	f.terminate(<-done)
+
+	if err := pool.Submit(func(conn *WasmWebWorkerConn) {
+		if conn.exited() {
+			t.Error("the job is dispatched to an exited worker")
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pool.Close()
+	if err := pool.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.startCount(); n != 2 {
+		t.Errorf("expect the worker to be re-spawned before the 2nd job, got %d starts", n)
+	}
+}
+
+func TestPoolDiscardEvents(t *testing.T) {
+	f := &fakeWorkers{}
+	pool := newFakePool(t, f, 1, 1)
+	if err := pool.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Submit(func(conn *WasmWebWorkerConn) {
+		// Leave the events undrained.
+		conn.eventCh <- types.MessageEventMessage{}
+		conn.eventCh <- types.MessageEventMessage{}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Submit(func(conn *WasmWebWorkerConn) {
+		if n := len(conn.eventCh); n != 0 {
+			t.Errorf("expect the events left by the previous job to be discarded, got %d", n)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pool.Close()
+	if err := pool.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolOutput(t *testing.T) {
+	var stdout, stderr strings.Builder
+	cases := map[string]struct {
+		stdout, stderr io.Writer
+		combined       bool
+	}{
+		"separate": {stdout: &stdout, stderr: &stderr},
+		"combined": {stdout: &stdout, stderr: &stdout, combined: true},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &fakeWorkers{}
+			pool := newFakePool(t, f, 2, 0)
+			pool.Stdout, pool.Stderr = c.stdout, c.stderr
+			var conns []*WasmWebWorkerConn
+			poolStartConn = func(ctx context.Context, conn *WasmWebWorkerConn) error {
+				conns = append(conns, conn)
+				return f.start(ctx, conn)
+			}
+			if err := pool.Start(); err != nil {
+				t.Fatal(err)
+			}
+			pool.Close()
+			if err := pool.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			for _, conn := range conns {
+				// The combined output is what makes the worker flush its stdout and stderr in order.
+				if got := interfaceEqual(conn.Stdout, conn.Stderr); got != c.combined {
+					t.Errorf("worker %s: got combined output %t, want %t", conn.Name, got, c.combined)
+				}
+			}
+			if !interfaceEqual(conns[0].Stdout, conns[1].Stdout) {
+				t.Error("expect the workers to share the same locked writer")
+			}
+		})
+	}
+}
@@ -1,8 +1,27 @@
-importScripts(location.origin + '/wasm_exec.js');
+importScripts({{.GlueToJS}});
 
 const go = new Go();
 go.argv = {{.ArgsToJS}}
 go.env = {{.EnvToJS}}
-WebAssembly.instantiateStreaming(fetch("{{.Path}}"), go.importObject).then((result) => {
+{{- if .TinyGo}}
+
+{{template "tinygo" .}}
+{{- end}}
+{{- if .SharedModule}}
+
+// The compiled WebAssembly.Module is sent by the controller right after this worker is created, instead of fetching and compiling the WASM.
+const onModule = (e) => {
+    const frame = e.data;
+    if (frame && frame["{{.EnvelopeMarker}}"] === {{.ProtocolVersion}} && frame.kind === "{{.ModuleKind}}") {
+        removeEventListener("message", onModule);
+        WebAssembly.instantiate(frame.payload, go.importObject).then((instance) => {
+            go.run(instance);
+        });
+    }
+};
+addEventListener("message", onModule);
+{{- else}}
+WebAssembly.instantiateStreaming(fetch({{.PathToJS}}), go.importObject).then((result) => {
     go.run(result.instance);
 });
+{{- end}}
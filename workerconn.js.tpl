@@ -0,0 +1,105 @@
+// Wrap the payload into the envelope of the control frames, which is distinguished from the user messages.
+function controlFrame(kind, payload) {
+    return {"{{.EnvelopeMarker}}": {{.ProtocolVersion}}, kind: kind, payload: payload};
+}
+{{- if .SharedModule}}
+
+// The compiled WebAssembly.Module is sent by the controller right after this worker is created, instead of fetching and compiling the WASM.
+const modulePromise = new Promise((resolve) => {
+    const onModule = (e) => {
+        const frame = e.data;
+        if (frame && frame["{{.EnvelopeMarker}}"] === {{.ProtocolVersion}} && frame.kind === "{{.ModuleKind}}") {
+            removeEventListener("message", onModule);
+            resolve(frame.payload);
+        }
+    };
+    addEventListener("message", onModule);
+});
+{{- end}}
+
+// Tell the Go program whether to flush its stdout/stderr in the order they are written, as the controller combines them.
+self["{{.OrderedOutputVar}}"] = {{.OrderedOutput}};
+
+{{template "output" .}}
+
+// Report the failure of starting the WASM to the controller, then close this worker.
+function startFailed(stage, err) {
+    self.postMessage(controlFrame("{{.StartErrorKind}}", JSON.stringify({stage: stage, message: String(err)})));
+    self.close();
+}
+
+try {
+    importScripts({{.GlueToJS}});
+} catch (err) {
+    startFailed("glue", err);
+    throw err;
+}
+
+const go = new Go();
+go.argv = {{.ArgsToJS}}
+go.env = {{.EnvToJS}}
+{{- if .TinyGo}}
+
+{{template "tinygo" .}}
+{{- end}}
+
+// Report the exit code of the Go program to the controller, then close this worker.
+// The glue script of TinyGo has no go.exit, hence it is called by the wrapped proc_exit instead.
+let exited = false;
+const goExit = go.exit;
+go.exit = (code) => {
+    exited = true;
+    if (goExit) {
+        goExit(code);
+    }
+    flushPendingOutput((msg, transfers) => self.postMessage(msg, transfers));
+    self.postMessage(controlFrame("{{.ExitKind}}", code));
+    self.close();
+};
+
+// Report the crash of the WASM without exiting, e.g. a WebAssembly trap, to the controller, then close this worker.
+// The trap either rejects the promise returned by go.run, or is thrown from an event handler calling into the Go program.
+let crashed = false;
+function crash(err) {
+    if (crashed || exited) {
+        return;
+    }
+    crashed = true;
+    flushPendingOutput((msg, transfers) => self.postMessage(msg, transfers));
+    self.postMessage(controlFrame("{{.CrashKind}}", String(err)));
+    self.close();
+}
+addEventListener("error", (e) => {
+    if (e.error instanceof WebAssembly.RuntimeError) {
+        crash(e.error);
+    }
+});
+
+{{template "console" .}}
+
+(async () => {
+    let resp, module, instance;
+{{- if .SharedModule}}
+    module = await modulePromise;
+{{- else}}
+    try {
+        resp = await fetch({{.PathToJS}});
+        if (!resp.ok) {
+            throw new Error(`${resp.status} ${resp.statusText}`);
+        }
+    } catch (err) {
+        return startFailed("fetch", err);
+    }
+    try {
+        module = await WebAssembly.compileStreaming(resp);
+    } catch (err) {
+        return startFailed("compile", err);
+    }
+{{- end}}
+    try {
+        instance = await WebAssembly.instantiate(module, go.importObject);
+    } catch (err) {
+        return startFailed("link", err);
+    }
+    go.run(instance).catch(crash);
+})();